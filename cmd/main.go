@@ -10,14 +10,22 @@ import (
 
 var (
 	cfgFile     string
+	profile     string // --profile 参数：使用的 profile
 	temperature float64
 	maxTokens   int
 	timeout     int
 	imagePath   string
+	imageDetail string // --image-detail 参数：图片解析精度
 	filePath    string // -f 参数：文件路径
 	editPath    string // -e 参数：编辑文件路径
 	executeMode bool   // -y 参数：是否直接执行命令
 	commandMode bool   // -c 参数：命令模式
+	showUsage   bool   // --usage 参数：在 stderr 输出 token 用量
+	showReason  bool   // --show-reasoning 参数：在 stderr 输出推理过程
+	reasoning   string // --reasoning 参数：推理强度
+	jsonMode    bool   // --json 参数：只输出 JSON
+	jsonSchema  string // --json-schema 参数：JSON Schema 文件路径
+	continueID  string // --continue 参数：继续的服务端响应 ID
 )
 
 var rootCmd = &cobra.Command{
@@ -53,19 +61,29 @@ Examples | 使用示例:
   docker ps | sse -c "检查容器状态"         # Generate commands | 生成命令
   kubectl get pods | sse -c "分析 Pod 状态" # Generate kubectl commands | 生成 kubectl 命令`,
 	Args: cobra.RangeArgs(0, 3),
-	Run:  runSSE,
+	// 所有子命令共用全局参数（如 --config、-t），在执行前统一传给 internal
+	PersistentPreRun: setAppConfig,
+	Run:              runSSE,
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default search: ./config.yaml, ~/.config/sse-client/config.yaml) | 配置文件 (默认搜索: ./config.yaml, ~/.config/sse-client/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "config profile to use (overrides SSE_PROFILE) | 使用的配置 profile（优先于 SSE_PROFILE）")
 	rootCmd.PersistentFlags().Float64VarP(&temperature, "temperature", "t", 0.7, "sampling temperature | 采样温度")
 	rootCmd.PersistentFlags().IntVarP(&maxTokens, "max-tokens", "m", 4096, "maximum tokens | 最大 token 数")
 	rootCmd.PersistentFlags().IntVar(&timeout, "timeout", 30, "request timeout in seconds | 请求超时时间（秒）")
 	rootCmd.PersistentFlags().StringVarP(&imagePath, "image", "i", "", "path to image file (for vision models) | 图片文件路径（用于视觉模型）")
+	rootCmd.PersistentFlags().StringVar(&imageDetail, "image-detail", "", "image detail level for OpenAI models: low, high, auto | 图片解析精度（OpenAI 模型）：low、high、auto")
 	rootCmd.PersistentFlags().StringVarP(&filePath, "file", "f", "", "path to file for content analysis | 文件路径（用于内容分析）")
 	rootCmd.PersistentFlags().StringVarP(&editPath, "edit", "e", "", "path to file for editing | 文件路径（用于编辑修改）")
 	rootCmd.PersistentFlags().BoolVarP(&executeMode, "yes", "y", false, "execute commands directly | 直接执行命令")
 	rootCmd.PersistentFlags().BoolVarP(&commandMode, "command", "c", false, "command mode for generating/executing commands | 命令模式，用于生成/执行命令")
+	rootCmd.PersistentFlags().BoolVar(&showReason, "show-reasoning", false, "print reasoning of thinking models to stderr | 在 stderr 输出推理模型的思考过程")
+	rootCmd.PersistentFlags().StringVar(&reasoning, "reasoning", "", "reasoning effort: off, low, medium, high | 推理强度：off、low、medium、high")
+	rootCmd.PersistentFlags().BoolVar(&jsonMode, "json", false, "output only JSON | 只输出 JSON")
+	rootCmd.PersistentFlags().StringVar(&jsonSchema, "json-schema", "", "JSON Schema file the output must conform to (implies --json) | 输出需符合的 JSON Schema 文件（隐含 --json）")
+	rootCmd.PersistentFlags().StringVar(&continueID, "continue", "", "continue from a stored response ID (OpenAI Responses API models) | 在服务端保存的响应之后继续对话（使用 Responses API 的 OpenAI 模型）")
+	rootCmd.PersistentFlags().BoolVar(&showUsage, "usage", false, "print token usage to stderr | 在 stderr 输出 token 用量")
 
 	// 添加所有子命令
 	for _, cmd := range internal.CreateCommands() {
@@ -80,21 +98,35 @@ func main() {
 	}
 }
 
-func runSSE(cmd *cobra.Command, args []string) {
-	// 设置应用程序配置
+// setAppConfig 设置应用程序配置；只有显式设置的 -t / -m / --timeout 才覆盖配置文件中的值
+func setAppConfig(cmd *cobra.Command, args []string) {
 	internal.SetAppConfig(internal.AppConfig{
-		CfgFile:     cfgFile,
-		Temperature: temperature,
-		MaxTokens:   maxTokens,
-		Timeout:     timeout,
-		ImagePath:   imagePath,
-		FilePath:    filePath,
-		EditPath:    editPath,
-		ExecuteMode: executeMode,
-		CommandMode: commandMode,
+		CfgFile:        cfgFile,
+		Profile:        profile,
+		Temperature:    temperature,
+		MaxTokens:      maxTokens,
+		TemperatureSet: cmd.Flags().Changed("temperature"),
+		MaxTokensSet:   cmd.Flags().Changed("max-tokens"),
+		Timeout:        timeout,
+		TimeoutSet:     cmd.Flags().Changed("timeout"),
+		ImagePath:      imagePath,
+		ImageDetail:    imageDetail,
+		FilePath:       filePath,
+		EditPath:       editPath,
+		ExecuteMode:    executeMode,
+		CommandMode:    commandMode,
+		ShowUsage:      showUsage,
+		ShowReasoning:  showReason,
+		Reasoning:      reasoning,
+		JSONMode:       jsonMode,
+		JSONSchemaPath: jsonSchema,
+		ContinueID:     continueID,
 	})
+}
 
+func runSSE(cmd *cobra.Command, args []string) {
 	// 调用处理函数
 	internal.HandleSSE(args)
 }
+
 // CI优化测试：这个注释变更应该触发构建
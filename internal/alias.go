@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sse-client/providers"
+)
+
+// Alias 是模型别名及其默认参数，如 `sse coder "..."`；命令行参数优先于别名中的设置
+type Alias struct {
+	Provider    string                 `yaml:"provider,omitempty"` // 为空时按路由规则确定，也可以在 model 中写成 provider/model
+	Model       string                 `yaml:"model"`
+	Temperature *float64               `yaml:"temperature,omitempty"`
+	MaxTokens   int                    `yaml:"max_tokens,omitempty"`
+	System      string                 `yaml:"system,omitempty"`     // 系统提示词
+	Reasoning   string                 `yaml:"reasoning,omitempty"`  // 推理强度：off、low、medium、high
+	ExtraBody   map[string]interface{} `yaml:"extra_body,omitempty"` // 合并到请求体中的额外字段
+}
+
+// target 返回别名指向的 provider/model，用于显示
+func (a Alias) target() string {
+	if a.Provider == "" {
+		return a.Model
+	}
+	return a.Provider + "/" + a.Model
+}
+
+// settings 返回别名设置的参数摘要，用于 sse list
+func (a Alias) settings() string {
+	var settings []string
+	if a.Temperature != nil {
+		settings = append(settings, fmt.Sprintf("temperature=%g", *a.Temperature))
+	}
+	if a.MaxTokens > 0 {
+		settings = append(settings, fmt.Sprintf("max_tokens=%d", a.MaxTokens))
+	}
+	if a.Reasoning != "" {
+		settings = append(settings, "reasoning="+a.Reasoning)
+	}
+	if a.System != "" {
+		settings = append(settings, "system")
+	}
+	if len(a.ExtraBody) > 0 {
+		keys := make([]string, 0, len(a.ExtraBody))
+		for key := range a.ExtraBody {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		settings = append(settings, "extra_body: "+strings.Join(keys, ", "))
+	}
+	return strings.Join(settings, ", ")
+}
+
+// validateAliases 在加载配置时检查别名：必须指定模型，provider 必须存在，推理强度必须合法
+func validateAliases(aliases map[string]Alias) error {
+	for name, alias := range aliases {
+		if alias.Model == "" {
+			return fmt.Errorf("aliases.%s: model is required", name)
+		}
+		if alias.Provider != "" && !containsString(providerNames(), alias.Provider) {
+			return fmt.Errorf("aliases.%s: unknown provider '%s' (available: %s)", name, alias.Provider, strings.Join(providerNames(), ", "))
+		}
+		if _, err := providers.ParseReasoningLevel(alias.Reasoning); err != nil {
+			return fmt.Errorf("aliases.%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// lookupAlias 返回名称对应的别名
+func lookupAlias(name string) (Alias, bool) {
+	if config == nil {
+		return Alias{}, false
+	}
+	alias, exists := config.Aliases[name]
+	return alias, exists
+}
+
+// aliasNames 返回按名称排序的所有别名
+func aliasNames() []string {
+	if config == nil {
+		return nil
+	}
+	names := make([]string, 0, len(config.Aliases))
+	for name := range config.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyAlias 记录使用的别名并填充命令行未设置的推理强度；temperature 和 max_tokens 由 resolveSettings 按优先级合并
+func applyAlias(name string, alias Alias) {
+	appConfig.alias = &alias
+	appConfig.aliasName = name
+	if alias.Reasoning != "" && appConfig.Reasoning == "" {
+		// 别名中的推理强度在加载配置时已校验
+		level, _ := providers.ParseReasoningLevel(alias.Reasoning)
+		appConfig.Reasoning = string(level)
+	}
+	appConfig.system = alias.System
+	appConfig.extraBody = alias.ExtraBody
+}
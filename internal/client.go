@@ -1,7 +1,13 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
+
 	"sse-client/providers"
 )
 
@@ -10,10 +16,7 @@ type SSEClient struct {
 }
 
 type Provider interface {
-	Stream(model, message string, temperature float64, maxTokens, timeout int) error
-	StreamWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) error
-	GetFullResponse(model, message string, temperature float64, maxTokens, timeout int) (string, error)
-	GetFullResponseWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) (string, error)
+	Stream(ctx context.Context, req *providers.ChatRequest, handler providers.EventHandler) error
 	SupportsModel(model string) bool
 }
 
@@ -22,11 +25,7 @@ func NewSSEClient() *SSEClient {
 	if config != nil && config.Providers != nil {
 		providerConfigs := make(map[string]providers.ProviderConfig)
 		for name, cfg := range config.Providers {
-			providerConfigs[name] = providers.ProviderConfig{
-				APIKey:  cfg.APIKey,
-				BaseURL: cfg.BaseURL,
-				Models:  cfg.Models,
-			}
+			providerConfigs[name] = toProviderConfig(cfg)
 		}
 		providers.SetConfig(providers.Config{
 			Providers: providerConfigs,
@@ -38,189 +37,331 @@ func NewSSEClient() *SSEClient {
 		})
 	}
 
-	return &SSEClient{
-		providers: map[string]Provider{
-			"bailian":   providers.NewBailianProvider(),
-			"openai":    providers.NewOpenAIProvider(),
-			"google":    providers.NewGoogleProvider(),
-			"anthropic": providers.NewAnthropicProvider(),
-			"deepseek":  providers.NewDeepSeekProvider(),
-		},
+	// 内置 provider 和 config.yaml 中声明的 provider 按各自的 type 创建
+	clientProviders := make(map[string]Provider)
+	for _, name := range providerNames() {
+		var cfg ProviderConfig
+		if config != nil {
+			cfg = config.Providers[name]
+		}
+		clientProviders[name] = newProvider(name, providerType(name, cfg))
 	}
+
+	return &SSEClient{providers: clientProviders}
 }
 
-// inferProviderFromModel 根据模型名称推断 provider
-func (c *SSEClient) inferProviderFromModel(model string) string {
-	// 首先检查自定义模型
-	globalConfig := providers.GetConfig()
-	if globalConfig.Providers != nil {
-		for providerName, cfg := range globalConfig.Providers {
-			for _, customModel := range cfg.Models {
-				if customModel == model {
-					return providerName
-				}
+// toProviderConfig 将 config.yaml 中的 provider 配置转换为 providers 包使用的配置；
+// api_key_cmd 等按需解析的密钥在 resolveProviderSecrets 中解析后再次传入
+func toProviderConfig(cfg ProviderConfig) providers.ProviderConfig {
+	pc := providers.ProviderConfig{
+		APIKey:     cfg.APIKey,
+		BaseURL:    cfg.BaseURL,
+		Models:     cfg.Models,
+		AuthHeader: cfg.AuthHeader,
+		AuthScheme: cfg.AuthScheme,
+		Headers:    cfg.Headers,
+		APIVersion: cfg.APIVersion,
+
+		CredentialsFile: credentialsFile(cfg),
+		Project:         cfg.Project,
+		Location:        cfg.Location,
+		TokenURL:        cfg.TokenURL,
+	}
+	for model, options := range cfg.ModelOptions {
+		if options.Deployment != "" {
+			if pc.Deployments == nil {
+				pc.Deployments = make(map[string]string)
+			}
+			pc.Deployments[model] = options.Deployment
+		}
+		if options.API != "" {
+			if pc.ModelAPIs == nil {
+				pc.ModelAPIs = make(map[string]string)
 			}
+			pc.ModelAPIs[model] = options.API
 		}
 	}
+	return pc
+}
 
-	// 然后使用默认的前缀匹配
-	// Qwen 系列模型 -> bailian
-	if len(model) >= 4 && model[:4] == "qwen" {
-		return "bailian"
+// newProvider 按 API 格式创建 provider
+func newProvider(name, apiType string) Provider {
+	switch apiType {
+	case "azure":
+		return providers.NewAzureOpenAIProvider(name)
+	case "anthropic":
+		return providers.NewAnthropicProvider(name)
+	case "vertex":
+		return providers.NewVertexProvider(name)
+	case "google":
+		return providers.NewGoogleProvider(name)
+	case "bailian":
+		return providers.NewBailianProvider(name)
+	case "deepseek":
+		return providers.NewDeepSeekProvider(name)
+	case "ollama":
+		return providers.NewOllamaProvider(name)
 	}
+	return providers.NewOpenAIProvider(name)
+}
 
-	// GPT 和 O1 系列模型 -> openai
-	if len(model) >= 3 && (model[:3] == "gpt" || model[:2] == "o1") {
-		return "openai"
+// IsProviderConfigured 检查 provider 是否配置了有效的 API key；Ollama 等本地 provider 只需出现在配置中，
+// Vertex AI 需要服务账号凭据
+func (c *SSEClient) IsProviderConfigured(providerName string) bool {
+	if cfg, exists := getProviderConfig(providerName); exists {
+		return hasCredentials(providerName, cfg) && cfg.APIKey != "your-api-key-here" && cfg.APIKey != "sk-test-key"
 	}
+	return false
+}
 
-	// Gemini 系列模型 -> google
-	if len(model) >= 6 && model[:6] == "gemini" {
-		return "google"
-	}
+// modelLister 是能够查询可用模型的 provider，如 Ollama 通过 /api/tags 返回本地已安装的模型
+type modelLister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
 
-	// Claude 系列模型 -> anthropic
-	if len(model) >= 6 && model[:6] == "claude" {
-		return "anthropic"
+// discoverModels 返回 provider 自动发现的模型；不支持发现或查询失败时返回 nil
+func (c *SSEClient) discoverModels(ctx context.Context, providerName string) ([]string, error) {
+	lister, ok := c.providers[providerName].(modelLister)
+	if !ok || !c.IsProviderConfigured(providerName) {
+		return nil, nil
 	}
-
-	// DeepSeek 系列模型 -> deepseek
-	if len(model) >= 8 && model[:8] == "deepseek" {
-		return "deepseek"
+	if err := resolveProviderSecrets(ctx, providerName); err != nil {
+		return nil, err
 	}
+	ctx, cancel := context.WithTimeout(ctx, modelDiscoveryTimeout)
+	defer cancel()
+	return lister.ListModels(ctx)
+}
 
-	// DeepSeek 模型（Hugging Face 格式）-> deepseek
-	if len(model) >= 11 && model[:11] == "deepseek-ai" {
-		return "deepseek"
-	}
+// modelDiscoveryTimeout 限制自动发现模型的耗时，本地服务未启动时不拖慢请求
+const modelDiscoveryTimeout = 2 * time.Second
 
-	return ""
+func (c *SSEClient) Stream(ctx context.Context, req *providers.ChatRequest, handler providers.EventHandler) (*providers.Response, error) {
+	return c.StreamWithProvider(ctx, "", req, handler)
 }
 
-// IsProviderConfigured 检查 provider 是否配置了有效的 API key
-func (c *SSEClient) IsProviderConfigured(providerName string) bool {
-	if cfg, exists := getProviderConfig(providerName); exists {
-		return cfg.APIKey != "" && cfg.APIKey != "your-api-key-here" && cfg.APIKey != "sk-test-key"
+// StreamWithProvider 支持明确指定 provider 或自动推断，事件实时交给 handler，并返回累积的完整响应
+func (c *SSEClient) StreamWithProvider(ctx context.Context, providerName string, req *providers.ChatRequest, handler providers.EventHandler) (*providers.Response, error) {
+	provider, providerName, err := c.resolveProvider(providerName, req.Model)
+	if err != nil {
+		return nil, err
 	}
-	return false
-}
 
-func (c *SSEClient) Stream(model, message string, temperature float64, maxTokens, timeout int) error {
-	return c.StreamWithImage(model, message, "", temperature, maxTokens, timeout)
+	fmt.Printf("Using %s provider for model: %s\n", providerName, req.Model)
+	return c.runWithFallback(ctx, providerName, provider, req, handler)
 }
 
-// StreamWithProvider 支持明确指定 provider 或自动推断
-func (c *SSEClient) StreamWithProvider(providerName, model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
-	// 如果明确指定了 provider
-	if providerName != "" {
-		return c.streamWithSpecificProvider(providerName, model, message, imagePath, temperature, maxTokens, timeout)
+// GetFullResponseWithProvider 获取完整的AI响应（非流式）
+func (c *SSEClient) GetFullResponseWithProvider(ctx context.Context, providerName string, req *providers.ChatRequest) (*providers.Response, error) {
+	provider, providerName, err := c.resolveProvider(providerName, req.Model)
+	if err != nil {
+		return nil, err
 	}
 
-	// 如果没有指定 provider，使用自动推断逻辑
-	return c.StreamWithImage(model, message, imagePath, temperature, maxTokens, timeout)
+	return c.runWithFallback(ctx, providerName, provider, req, nil)
 }
 
-// streamWithSpecificProvider 使用指定的 provider
-func (c *SSEClient) streamWithSpecificProvider(providerName, model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
-	// 检查 provider 是否存在
-	provider, exists := c.providers[providerName]
-	if !exists {
-		return fmt.Errorf("provider not found | 提供商未找到: %s\nAvailable providers | 可用提供商: bailian, openai, google, anthropic, deepseek", providerName)
+// runWithFallback 执行请求，遇到 429 / 5xx 或连接错误且尚未输出任何内容时，依次尝试配置中的备用 provider/model，
+// 并在 stderr 报告最终回答的 provider
+func (c *SSEClient) runWithFallback(ctx context.Context, providerName string, provider Provider, req *providers.ChatRequest, handler providers.EventHandler) (*providers.Response, error) {
+	targets := append([]fallbackTarget{{provider: providerName, model: req.Model}}, fallbackChain(providerName, req.Model)...)
+
+	var lastErr error
+	for i, target := range targets {
+		if i > 0 {
+			p, exists := c.providers[target.provider]
+			if !exists || !c.IsProviderConfigured(target.provider) {
+				fmt.Fprintf(os.Stderr, "⏭️  Skipping fallback %s: provider not configured | 跳过未配置的备用模型\n", target)
+				continue
+			}
+			if !providerAllowed(target.provider) {
+				fmt.Fprintf(os.Stderr, "⏭️  Skipping fallback %s: provider not allowed | 跳过不允许使用的备用模型\n", target)
+				continue
+			}
+			if req.PreviousResponseID != "" && !usesResponsesAPI(target.provider, target.model) {
+				fmt.Fprintf(os.Stderr, "⏭️  Skipping fallback %s: cannot continue a Responses API conversation | 备用模型无法继续服务端会话\n", target)
+				continue
+			}
+			if err := resolveProviderSecrets(ctx, target.provider); err != nil {
+				fmt.Fprintf(os.Stderr, "⏭️  Skipping fallback %s: %v | 跳过无法获取密钥的备用模型\n", target, err)
+				continue
+			}
+			provider = p
+			fmt.Fprintf(os.Stderr, "↪️  Falling back to %s | 切换到备用模型: %s\n", target, summarizeError(lastErr))
+		}
+
+		if i == 0 && req.PreviousResponseID != "" && !usesResponsesAPI(target.provider, target.model) {
+			return nil, fmt.Errorf("--continue requires a model using the Responses API (model_options.%s.api: responses) | --continue 需要使用 Responses API 的模型", target.model)
+		}
+		// api_key_cmd / api_key_file / ${ENV_VAR} 只为实际使用的 provider 解析
+		if i == 0 {
+			if err := resolveProviderSecrets(ctx, target.provider); err != nil {
+				return nil, err
+			}
+		}
+
+		// 每个目标使用独立的请求副本，model_options 按该目标的模型生效
+		attemptReq := *req
+		attemptReq.Model = target.model
+		if err := applyModelOptions(target.provider, &attemptReq); err != nil {
+			return nil, err
+		}
+
+		// 输出一旦开始就不会再切换，因此在备用目标的第一个内容事件之前报告回答的 provider
+		targetHandler := handler
+		if i > 0 {
+			reported := false
+			targetHandler = func(ev providers.StreamEvent) {
+				if !reported && isContentEvent(ev) {
+					reported = true
+					fmt.Fprintf(os.Stderr, "✅ Answered by %s | 由备用模型回答\n", target)
+				}
+				if handler != nil {
+					handler(ev)
+				}
+			}
+		}
+
+		response, err := c.run(ctx, target.provider, provider, &attemptReq, targetHandler)
+		if err == nil || hasContent(response) || !shouldFallback(ctx, err) {
+			return response, err
+		}
+		lastErr = err
 	}
+	return nil, lastErr
+}
 
-	// 检查 provider 是否配置了 API key
-	if !c.IsProviderConfigured(providerName) {
-		return fmt.Errorf("provider '%s' is not configured. Please configure the API key first", providerName)
+// applyModelOptions 用配置文件中该模型的 model_options 填充请求里未设置的参数
+func applyModelOptions(providerName string, req *providers.ChatRequest) error {
+	cfg, exists := getProviderConfig(providerName)
+	if !exists {
+		return nil
+	}
+	options, exists := cfg.ModelOptions[req.Model]
+	if !exists {
+		return nil
 	}
 
-	fmt.Printf("Using %s provider for model: %s\n", providerName, model)
+	if req.Reasoning == providers.ReasoningDefault && options.Reasoning != "" {
+		level, err := providers.ParseReasoningLevel(options.Reasoning)
+		if err != nil {
+			return fmt.Errorf("%s.model_options.%s: %v", providerName, req.Model, err)
+		}
+		req.Reasoning = level
+	}
 
-	if imagePath != "" {
-		return provider.StreamWithImage(model, message, imagePath, temperature, maxTokens, timeout)
+	// 请求中（来自别名）已有的字段优先
+	if len(options.ExtraBody) > 0 {
+		extra := make(map[string]interface{}, len(options.ExtraBody)+len(req.ExtraBody))
+		for key, value := range options.ExtraBody {
+			extra[key] = value
+		}
+		for key, value := range req.ExtraBody {
+			extra[key] = value
+		}
+		req.ExtraBody = extra
 	}
-	return provider.Stream(model, message, temperature, maxTokens, timeout)
+	return nil
 }
 
-func (c *SSEClient) StreamWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
-	// 首先尝试根据模型名称推断 provider
-	if providerName := c.inferProviderFromModel(model); providerName != "" {
-		if provider, exists := c.providers[providerName]; exists {
-			// 检查该 provider 是否配置了 API key
-			if c.IsProviderConfigured(providerName) {
-				fmt.Printf("Using %s provider for model: %s\n", providerName, model)
-				if imagePath != "" {
-					return provider.StreamWithImage(model, message, imagePath, temperature, maxTokens, timeout)
-				}
-				return provider.Stream(model, message, temperature, maxTokens, timeout)
-			} else {
-				return fmt.Errorf("provider '%s' is not configured for model '%s'. Please configure the API key first", providerName, model)
+// run 执行流式请求，在转发事件的同时累积完整响应；出错时返回已收到的部分响应。
+// 429 / 5xx 等临时错误按 provider 的重试策略重试，但已经有内容交给 handler 后不再重试，以免输出重复
+func (c *SSEClient) run(ctx context.Context, providerName string, provider Provider, req *providers.ChatRequest, handler providers.EventHandler) (*providers.Response, error) {
+	policy := retryPolicy(providerName)
+	for attempt := 1; ; attempt++ {
+		var acc providers.Accumulator
+		err := provider.Stream(ctx, req, func(ev providers.StreamEvent) {
+			acc.Add(ev)
+			if handler != nil {
+				handler(ev)
 			}
+		})
+		response := acc.Response()
+		if err == nil || hasContent(response) || attempt >= policy.MaxAttempts || !shouldRetry(err) {
+			return response, err
 		}
-	}
 
-	// 如果推断不出来，返回错误并提示用户明确指定 provider
-	return fmt.Errorf("cannot determine provider for model '%s'. Please specify provider explicitly:\n"+
-		"  sse bailian %s \"your message\"     # for Qwen models\n"+
-		"  sse openai %s \"your message\"      # for GPT models\n"+
-		"  sse google %s \"your message\"      # for Gemini models\n"+
-		"  sse anthropic %s \"your message\"   # for Claude models\n"+
-		"Or use a recognizable model name like: qwen-max, gpt-4o, gemini-2.5-pro, claude-3-5-sonnet-20241022",
-		model, model, model, model, model)
+		var retryAfter time.Duration
+		var apiErr *providers.APIError
+		if errors.As(err, &apiErr) {
+			retryAfter = apiErr.RetryAfter
+		}
+		wait := policy.delay(attempt, retryAfter)
+		fmt.Fprintf(os.Stderr, "🔁 Retry %d/%d in %s | 第 %d 次重试，等待 %s: %s\n",
+			attempt, policy.MaxAttempts-1, wait.Round(time.Millisecond), attempt, wait.Round(time.Millisecond), summarizeError(err))
+		if err := sleepContext(ctx, wait); err != nil {
+			return response, err
+		}
+	}
 }
 
-// GetFullResponseWithProvider 获取完整的AI响应（非流式）
-func (c *SSEClient) GetFullResponseWithProvider(providerName, model, message, imagePath string, temperature float64, maxTokens, timeout int) (string, error) {
-	// 如果明确指定了 provider
-	if providerName != "" {
-		return c.getFullResponseWithSpecificProvider(providerName, model, message, imagePath, temperature, maxTokens, timeout)
+// isContentEvent 判断事件是否为交给用户的内容（回答、推理或工具调用）
+func isContentEvent(ev providers.StreamEvent) bool {
+	switch ev.Type {
+	case providers.EventTextDelta, providers.EventReasoningDelta, providers.EventToolCallDelta:
+		return true
 	}
+	return false
+}
 
-	// 如果没有指定 provider，使用自动推断逻辑
-	return c.GetFullResponseAuto(model, message, imagePath, temperature, maxTokens, timeout)
+// hasContent 判断响应中是否已有交给用户的内容（回答、推理或工具调用）
+func hasContent(response *providers.Response) bool {
+	return response != nil && (response.Text != "" || response.Reasoning != "" || len(response.ToolCalls) > 0)
 }
 
-// getFullResponseWithSpecificProvider 使用指定的 provider 获取完整响应
-func (c *SSEClient) getFullResponseWithSpecificProvider(providerName, model, message, imagePath string, temperature float64, maxTokens, timeout int) (string, error) {
-	// 检查 provider 是否存在
-	provider, exists := c.providers[providerName]
-	if !exists {
-		return "", fmt.Errorf("provider not found | 提供商未找到: %s\nAvailable providers | 可用提供商: bailian, openai, google, anthropic, deepseek", providerName)
+// summarizeError 截断错误信息，避免重试日志中出现整页 HTML 错误页
+func summarizeError(err error) string {
+	msg := strings.Join(strings.Fields(err.Error()), " ")
+	if len(msg) > 200 {
+		msg = msg[:200] + "..."
 	}
+	return msg
+}
 
-	// 检查 provider 是否配置了 API key
-	if !c.IsProviderConfigured(providerName) {
-		return "", fmt.Errorf("provider '%s' is not configured. Please configure the API key first", providerName)
-	}
+// resolveProvider 返回明确指定的 provider，未指定时根据模型名称自动推断
+func (c *SSEClient) resolveProvider(providerName, model string) (Provider, string, error) {
+	// 如果明确指定了 provider
+	if providerName != "" {
+		// 检查 provider 是否存在
+		provider, exists := c.providers[providerName]
+		if !exists {
+			return nil, "", fmt.Errorf("provider not found | 提供商未找到: %s\nAvailable providers | 可用提供商: %s", providerName, strings.Join(providerNames(), ", "))
+		}
+
+		if !providerAllowed(providerName) {
+			return nil, "", fmt.Errorf("provider '%s' is not allowed here (allowed_providers: %s)", providerName, strings.Join(config.AllowedProviders, ", "))
+		}
 
-	if imagePath != "" {
-		return provider.GetFullResponseWithImage(model, message, imagePath, temperature, maxTokens, timeout)
+		// 检查 provider 是否配置了 API key
+		if !c.IsProviderConfigured(providerName) {
+			return nil, "", fmt.Errorf("provider '%s' is not configured. Please configure the API key first", providerName)
+		}
+
+		return provider, providerName, nil
 	}
-	return provider.GetFullResponse(model, message, temperature, maxTokens, timeout)
-}
 
-// GetFullResponseAuto 自动推断 provider 并获取完整响应
-func (c *SSEClient) GetFullResponseAuto(model, message, imagePath string, temperature float64, maxTokens, timeout int) (string, error) {
-	// 首先尝试根据模型名称推断 provider
-	if providerName := c.inferProviderFromModel(model); providerName != "" {
+	// 如果没有指定 provider，按路由规则根据模型名称推断
+	if providerName := c.route(model).provider; providerName != "" {
 		if provider, exists := c.providers[providerName]; exists {
+			if !providerAllowed(providerName) {
+				return nil, "", fmt.Errorf("model '%s' routes to provider '%s', which is not allowed here (allowed_providers: %s)", model, providerName, strings.Join(config.AllowedProviders, ", "))
+			}
 			// 检查该 provider 是否配置了 API key
-			if c.IsProviderConfigured(providerName) {
-				if imagePath != "" {
-					return provider.GetFullResponseWithImage(model, message, imagePath, temperature, maxTokens, timeout)
-				}
-				return provider.GetFullResponse(model, message, temperature, maxTokens, timeout)
-			} else {
-				return "", fmt.Errorf("provider '%s' is not configured for model '%s'. Please configure the API key first", providerName, model)
+			if !c.IsProviderConfigured(providerName) {
+				return nil, "", fmt.Errorf("provider '%s' is not configured for model '%s'. Please configure the API key first", providerName, model)
 			}
+			return provider, providerName, nil
 		}
 	}
 
 	// 如果推断不出来，返回错误并提示用户明确指定 provider
-	return "", fmt.Errorf("cannot determine provider for model '%s'. Please specify provider explicitly:\n"+
+	return nil, "", fmt.Errorf("cannot determine provider for model '%s'. Please specify provider explicitly:\n"+
+		"  sse <provider>/%s \"your message\"  # provider/model form\n"+
 		"  sse bailian %s \"your message\"     # for Qwen models\n"+
 		"  sse openai %s \"your message\"      # for GPT models\n"+
 		"  sse google %s \"your message\"      # for Gemini models\n"+
 		"  sse anthropic %s \"your message\"   # for Claude models\n"+
-		"Or use a recognizable model name like: qwen-max, gpt-4o, gemini-2.5-pro, claude-3-5-sonnet-20241022",
-		model, model, model, model, model)
+		"  sse ollama %s \"your message\"      # for local Ollama models\n"+
+		"Or add a rule under routes in config.yaml (see: sse route %s)",
+		model, model, model, model, model, model, model, model)
 }
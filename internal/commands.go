@@ -1,11 +1,15 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"sse-client/providers"
 )
 
 // CreateCommands 创建所有子命令
@@ -17,6 +21,9 @@ func CreateCommands() []*cobra.Command {
 		createAddCmd(),
 		createSetCmd(),
 		createEnvCmd(),
+		createRouteCmd(),
+		createTrustCmd(),
+		createProfileCmd(),
 	}
 }
 
@@ -46,16 +53,47 @@ func ListModels(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	// 遍历配置中的所有 providers
-	for provider, cfg := range config.Providers {
-		if len(cfg.Models) > 0 {
-			fmt.Printf("📦 %s (%d models):\n", strings.ToUpper(provider), len(cfg.Models))
-			for _, model := range cfg.Models {
-				fmt.Printf("  • %s\n", model)
+	client := NewSSEClient()
+
+	// 遍历配置中的所有 providers（包括自定义 provider），本地 provider 同时列出自动发现的模型
+	for _, provider := range configuredProviderNames() {
+		cfg, _ := getProviderConfig(provider)
+		models := cfg.Models
+		local, err := client.discoverModels(context.Background(), provider)
+		if err != nil {
+			fmt.Printf("⚠️  %s: failed to discover local models | 获取本地模型失败: %v\n\n", strings.ToUpper(provider), err)
+		}
+		for _, model := range local {
+			if !containsString(models, model) {
+				models = append(models, model)
+			}
+		}
+
+		if len(models) > 0 {
+			fmt.Printf("📦 %s (%d models):\n", strings.ToUpper(provider), len(models))
+			for _, model := range models {
+				if containsString(local, model) {
+					fmt.Printf("  • %s (local)\n", model)
+				} else {
+					fmt.Printf("  • %s\n", model)
+				}
 			}
 			fmt.Println()
 		}
 	}
+
+	if names := aliasNames(); len(names) > 0 {
+		fmt.Printf("🏷️  ALIASES | 别名 (%d):\n", len(names))
+		for _, name := range names {
+			alias := config.Aliases[name]
+			if settings := alias.settings(); settings != "" {
+				fmt.Printf("  • %s → %s (%s)\n", name, alias.target(), settings)
+			} else {
+				fmt.Printf("  • %s → %s\n", name, alias.target())
+			}
+		}
+		fmt.Println()
+	}
 }
 
 func createTestCmd() *cobra.Command {
@@ -86,18 +124,30 @@ func TestProvider(cmd *cobra.Command, args []string) {
 }
 
 func createConfigCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "config",
+	cmd := &cobra.Command{
+		Use:   "config [alias]",
 		Short: "Show current configuration | 显示当前配置",
 		Long: `Show current configuration including environment variables and config file settings | 显示当前配置，包括环境变量和配置文件设置
 
 This command shows all configuration values that would be used, with environment variables taking precedence over config file values.
 此命令显示将要使用的所有配置值，环境变量优先于配置文件值。
 
+Values are resolved in this order (later wins) | 参数按以下顺序生效（后者优先）:
+  built-in defaults < install dir < /etc/sse-client < ~/.config/sse-client < ./config.yaml
+  < alias < environment (SSE_TIMEOUT, SSE_MAX_TOKENS, SSE_TEMPERATURE) < flags that were set
+
 Examples | 示例:
-  sse config            # Show all configuration | 显示所有配置`,
-		Run: showConfig,
+  sse config                    # Show all configuration, keys masked | 显示所有配置（隐藏 key）
+  sse config --reveal           # Show full API keys | 显示完整的 API key
+  sse config --explain          # Show where each value comes from | 显示每个参数的来源
+  sse config --explain coder    # Include an alias | 包含别名的设置
+  sse config --explain -t 0.2   # Include flags | 包含命令行参数`,
+		Args: cobra.MaximumNArgs(1),
+		Run:  showConfig,
 	}
+	cmd.Flags().Bool("explain", false, "show where each effective value comes from | 显示每个生效参数的来源")
+	cmd.Flags().Bool("reveal", false, "show full API keys (runs api_key_cmd) | 显示完整的 API key（会执行 api_key_cmd）")
+	return cmd
 }
 
 func createAddCmd() *cobra.Command {
@@ -143,6 +193,17 @@ func showConfig(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	if explain, _ := cmd.Flags().GetBool("explain"); explain {
+		explainConfig(args)
+		return
+	}
+	if len(args) > 0 {
+		fmt.Println("An alias can only be given with --explain | 只有 --explain 可以指定别名")
+		os.Exit(1)
+	}
+
+	reveal, _ := cmd.Flags().GetBool("reveal")
+
 	fmt.Println("Configuration:")
 	fmt.Println()
 
@@ -153,36 +214,102 @@ func showConfig(cmd *cobra.Command, args []string) {
 		fmt.Println()
 	}
 
-	providers := []string{"bailian", "openai", "google", "anthropic", "deepseek"}
-	for _, provider := range providers {
+	for _, provider := range configuredProviderNames() {
 		if cfg, exists := getProviderConfig(provider); exists {
 			status := "❌"
-			if cfg.APIKey != "" {
+			if hasCredentials(provider, cfg) {
 				status = "✅"
 			}
 
 			fmt.Printf("%s %s:\n", status, strings.ToUpper(provider))
 
-			// API Key 环境变量格式
-			apiKeyEnv := strings.ToUpper(provider) + "_API_KEY"
-			if cfg.APIKey != "" {
-				fmt.Printf("  %s=%s\n", apiKeyEnv, cfg.APIKey)
+			// 自定义 provider 显示其 API 格式
+			if !containsString(builtinProviders, provider) {
+				fmt.Printf("  Type: %s\n", providerType(provider, cfg))
+			}
+
+			// API Key 环境变量格式，默认隐藏 key，--reveal 时显示完整的 key
+			apiKeyEnv := providers.EnvPrefix(provider) + "_API_KEY"
+			if cfg.APIKey != "" || cfg.APIKeyCmd != "" || cfg.APIKeyFile != "" {
+				fmt.Printf("  %s=%s\n", apiKeyEnv, describeAPIKey(provider, cfg, reveal))
 			} else {
 				fmt.Printf("  %s=not_configured\n", apiKeyEnv)
 			}
 
 			// Base URL 环境变量格式
-			baseUrlEnv := strings.ToUpper(provider) + "_BASE_URL"
+			baseUrlEnv := providers.EnvPrefix(provider) + "_BASE_URL"
 			if cfg.BaseURL != "" {
 				fmt.Printf("  %s=%s\n", baseUrlEnv, cfg.BaseURL)
 			}
 
+			// Vertex AI 使用服务账号凭据文件认证
+			if providerType(provider, cfg) == "vertex" {
+				if file := credentialsFile(cfg); file != "" {
+					fmt.Printf("  Credentials: %s\n", file)
+				} else {
+					fmt.Println("  Credentials: not_configured (credentials_file or GOOGLE_APPLICATION_CREDENTIALS)")
+				}
+			}
+
 			fmt.Printf("  Models: %d\n", len(cfg.Models))
 			fmt.Println()
 		}
 	}
 }
 
+// explainConfig 显示读取的配置文件和每个生效参数的来源
+func explainConfig(args []string) {
+	var alias *Alias
+	var aliasName string
+	if len(args) == 1 {
+		a, exists := lookupAlias(args[0])
+		if !exists {
+			fmt.Printf("Alias not found | 别名未找到: %s\n", args[0])
+			os.Exit(1)
+		}
+		alias, aliasName = &a, args[0]
+	}
+
+	fmt.Println("Config files (low → high priority) | 配置文件（优先级从低到高）:")
+	for _, layer := range configLayers(appConfig.CfgFile) {
+		status := "not found | 不存在"
+		for _, loaded := range loadedLayers {
+			if loaded == layer {
+				status = "loaded | 已加载"
+			}
+		}
+		if status != "not found | 不存在" && layer.name == "repo" && !repoTrusted {
+			status = "loaded, untrusted: secrets ignored | 已加载，未信任，忽略敏感字段"
+		}
+		fmt.Printf("  %-8s %s (%s)\n", layer.name, layer.path, status)
+	}
+	fmt.Println()
+
+	_, _, _, settings, err := resolveSettings(alias, aliasName)
+	if err != nil {
+		fmt.Printf("Error | 错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	defaultProvider, defaultModel := getDefaultProvider()
+	settings = append(settings,
+		setting{name: "profile", value: activeProfile, source: activeProfileSource},
+		setting{name: "default_provider", value: defaultProvider, source: settingSource("default_provider")},
+		setting{name: "default_model", value: defaultModel, source: settingSource("default_model")},
+	)
+
+	fmt.Println("Effective settings | 生效的参数:")
+	for _, s := range settings {
+		value := s.value
+		if value == "" {
+			value = "(unset)"
+		}
+		fmt.Printf("  %-17s %-14s ← %s\n", s.name, value, s.source)
+	}
+	fmt.Println()
+	fmt.Printf("Config changes are saved to | 配置修改写入: %s\n", saveTarget())
+}
+
 func addModel(cmd *cobra.Command, args []string) {
 	if len(args) != 3 || args[1] != "model" {
 		fmt.Println("Usage: sse add <provider> model <model_name>")
@@ -193,27 +320,19 @@ func addModel(cmd *cobra.Command, args []string) {
 	provider := args[0]
 	modelName := args[2]
 
-	// 验证 provider 是否有效
-	validProviders := []string{"bailian", "openai", "google", "anthropic", "deepseek"}
-	isValidProvider := false
-	for _, p := range validProviders {
-		if p == provider {
-			isValidProvider = true
-			break
-		}
+	if err := loadConfig(appConfig.CfgFile); err != nil {
+		fmt.Printf("Error loading config | 配置加载错误: %v\n", err)
+		os.Exit(1)
 	}
 
-	if !isValidProvider {
+	// 验证 provider 是否有效：内置 provider 或 config.yaml 中声明的 provider
+	validProviders := providerNames()
+	if !containsString(validProviders, provider) {
 		fmt.Printf("❌ Invalid provider: %s\n", provider)
 		fmt.Printf("Available providers | 可用提供商: %s\n", strings.Join(validProviders, ", "))
 		os.Exit(1)
 	}
 
-	if err := loadConfig(appConfig.CfgFile); err != nil {
-		fmt.Printf("Error loading config | 配置加载错误: %v\n", err)
-		os.Exit(1)
-	}
-
 	// 添加模型到配置
 	if err := addModelToConfig(provider, modelName); err != nil {
 		fmt.Printf("Error adding model | 添加模型错误: %v\n", err)
@@ -275,24 +394,37 @@ func showEnvVars(cmd *cobra.Command, args []string) {
 	fmt.Println("=" + strings.Repeat("=", 50))
 	fmt.Println()
 
-	providers := []struct {
+	type envProvider struct {
 		name    string
 		display string
 		baseURL string
-	}{
+	}
+	envProviders := []envProvider{
 		{"bailian", "阿里云百炼 (Bailian)", "https://dashscope.aliyuncs.com/compatible-mode/v1"},
 		{"openai", "OpenAI", "https://api.openai.com/v1"},
 		{"google", "Google Gemini", "https://generativelanguage.googleapis.com/v1beta"},
 		{"anthropic", "Anthropic Claude", "https://api.anthropic.com"},
 		{"deepseek", "DeepSeek", "https://api.deepseek.com/v1"},
+		{"ollama", "Ollama (local, no API key needed)", providers.OllamaDefaultBaseURL},
+	}
+
+	// config.yaml 中声明的自定义 provider 同样支持 <NAME>_API_KEY / <NAME>_BASE_URL
+	if err := loadConfig(appConfig.CfgFile); err == nil {
+		for _, name := range configuredProviderNames() {
+			if containsString(builtinProviders, name) {
+				continue
+			}
+			cfg, _ := getProviderConfig(name)
+			envProviders = append(envProviders, envProvider{name, fmt.Sprintf("%s (%s)", name, providerType(name, cfg)), cfg.BaseURL})
+		}
 	}
 
-	for i, provider := range providers {
+	for i, provider := range envProviders {
 		fmt.Printf("📌 %s\n", provider.display)
-		fmt.Printf("   API Key:  %s_API_KEY\n", strings.ToUpper(provider.name))
-		fmt.Printf("   Base URL: %s_BASE_URL (optional, default: %s)\n", strings.ToUpper(provider.name), provider.baseURL)
+		fmt.Printf("   API Key:  %s_API_KEY\n", providers.EnvPrefix(provider.name))
+		fmt.Printf("   Base URL: %s_BASE_URL (optional, default: %s)\n", providers.EnvPrefix(provider.name), provider.baseURL)
 
-		if i < len(providers)-1 {
+		if i < len(envProviders)-1 {
 			fmt.Println()
 		}
 	}
@@ -314,3 +446,281 @@ func showEnvVars(cmd *cobra.Command, args []string) {
 	fmt.Println("   sse config                    # Show current configuration | 显示当前配置")
 	fmt.Println("   sse test <provider>           # Test provider setup | 测试提供商设置")
 }
+
+func createRouteCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "route [model]",
+		Short: "Explain which provider a model is routed to | 显示模型路由到的提供商",
+		Long: `Explain which provider a model is routed to and which rule matched | 显示模型路由到的提供商及匹配的规则
+
+Routing order | 路由顺序:
+  1. provider/model form, e.g. openrouter/anthropic/claude-3.5 | provider/model 形式
+  2. models lists in config.yaml | config.yaml 中的 models 列表
+  3. routes in config.yaml, in order | config.yaml 中按顺序匹配的 routes
+  4. models installed on local servers (Ollama) | 本地服务已安装的模型
+  5. built-in rules | 内置规则
+
+Examples | 示例:
+  sse route o4-mini                          # Explain routing | 显示路由结果
+  sse route openrouter/anthropic/claude-3.5  # Explicit provider | 明确指定提供商
+  sse route                                  # List all rules | 列出所有规则`,
+		Args: cobra.MaximumNArgs(1),
+		Run:  showRoute,
+	}
+}
+
+func showRoute(cmd *cobra.Command, args []string) {
+	if err := loadConfig(appConfig.CfgFile); err != nil {
+		fmt.Printf("Error loading config | 配置加载错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 未指定模型时按匹配顺序列出所有规则
+	if len(args) == 0 {
+		fmt.Println("Routes | 路由规则:")
+		if config != nil {
+			for i, rule := range config.Routes {
+				fmt.Printf("  routes[%d]: %s\n", i, rule)
+			}
+		}
+		for _, rule := range defaultRoutes {
+			fmt.Printf("  built-in: %s\n", rule)
+		}
+		return
+	}
+
+	client := NewSSEClient()
+	model := args[0]
+	var result routeResult
+	if providerName, rest, ok := splitProviderModel(model); ok {
+		model = rest
+		result = routeResult{provider: providerName, reason: "explicit provider/model"}
+	} else {
+		result = client.route(model)
+	}
+
+	if result.provider == "" {
+		fmt.Printf("❌ No rule matches model '%s' | 没有匹配该模型的规则\n", model)
+		fmt.Println("Add a rule under routes in config.yaml or use the provider/model form | 请在 config.yaml 的 routes 中添加规则或使用 provider/model 形式")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Model | 模型: %s\n", model)
+	fmt.Printf("Provider | 提供商: %s\n", result.provider)
+	fmt.Printf("Matched | 匹配: %s\n", result.reason)
+	if !client.IsProviderConfigured(result.provider) {
+		fmt.Printf("⚠️  Provider '%s' is not configured | 提供商未配置\n", result.provider)
+	}
+	if !providerAllowed(result.provider) {
+		fmt.Printf("⚠️  Provider '%s' is not in allowed_providers | 提供商不在 allowed_providers 中\n", result.provider)
+	}
+}
+
+func createTrustCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trust",
+		Short: "Trust the project .sse.yaml | 信任当前项目的 .sse.yaml",
+		Long: `Allow the nearest .sse.yaml (searched from the current directory upward) to set secrets | 允许最近的 .sse.yaml 设置敏感字段
+
+Untrusted project files cannot set api_key, headers or credentials_file, and cannot change
+base_url / token_url of built-in or already configured providers.
+未信任的项目配置不能设置 api_key、headers、credentials_file，也不能修改内置或已配置 provider 的 base_url / token_url。
+The project directory is recorded in trusted_projects of the user config | 项目目录记录在用户配置的 trusted_projects 中。
+
+Examples | 示例:
+  sse trust            # Trust the current project | 信任当前项目
+  sse trust --revoke   # Remove the trust | 取消信任`,
+		Args: cobra.NoArgs,
+		Run:  trustProject,
+	}
+	cmd.Flags().Bool("revoke", false, "remove the project from trusted_projects | 取消信任")
+	return cmd
+}
+
+func trustProject(cmd *cobra.Command, args []string) {
+	path := findProjectConfig()
+	if path == "" {
+		fmt.Printf("No %s found in this directory or its parents | 当前目录及上级目录中没有 %s\n", projectConfigName, projectConfigName)
+		os.Exit(1)
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		fmt.Printf("Error | 错误: %v\n", err)
+		os.Exit(1)
+	}
+	revoke, _ := cmd.Flags().GetBool("revoke")
+
+	err = updateConfigFileAt(userConfigPath(), func(root *yaml.Node) error {
+		trusted := mappingValue(root, "trusted_projects", yaml.SequenceNode)
+		if trusted.Kind != yaml.SequenceNode {
+			return fmt.Errorf("trusted_projects in %s is not a list", userConfigPath())
+		}
+		var kept []*yaml.Node
+		for _, item := range trusted.Content {
+			if !sameDir(expandHome(item.Value), dir) {
+				kept = append(kept, item)
+			}
+		}
+		if !revoke {
+			kept = append(kept, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: dir})
+		}
+		trusted.Content = kept
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Error updating config | 配置更新错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	if revoke {
+		fmt.Printf("✅ No longer trusting | 已取消信任: %s\n", dir)
+	} else {
+		fmt.Printf("✅ Trusted | 已信任: %s\n", dir)
+	}
+}
+
+func createProfileCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage config profiles | 管理配置 profile",
+		Long: `Manage named config profiles | 管理命名的配置 profile
+
+A profile overrides providers, defaults and routing on top of the config files.
+profile 在配置文件之上覆盖 provider、默认模型和路由等设置。
+Selected by --profile, then SSE_PROFILE, then the profile saved by "sse profile use".
+选择顺序：--profile，其次是 SSE_PROFILE，最后是 "sse profile use" 保存的 profile。
+
+Examples | 示例:
+  sse profile list             # List profiles | 列出所有 profile
+  sse profile use work         # Save the active profile | 保存当前 profile
+  sse profile use --none       # Stop using a profile | 不使用 profile
+  sse profile show work        # Show a profile | 显示 profile 内容
+  sse --profile personal "hi"  # Use a profile once | 临时使用 profile`,
+	}
+
+	useCmd := &cobra.Command{
+		Use:   "use <profile>",
+		Short: "Set the active profile | 设置当前 profile",
+		Args:  cobra.RangeArgs(0, 1),
+		Run:   useProfile,
+	}
+	useCmd.Flags().Bool("none", false, "stop using a profile | 不使用 profile")
+
+	showCmd := &cobra.Command{
+		Use:   "show [profile]",
+		Short: "Show a profile (default: the active one) | 显示 profile（默认当前 profile）",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   showProfile,
+	}
+	showCmd.Flags().Bool("reveal", false, "show full API keys and headers | 显示完整的 API key 和请求头")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List profiles | 列出所有 profile",
+			Args:  cobra.NoArgs,
+			Run:   listProfiles,
+		},
+		useCmd,
+		showCmd,
+	)
+	return cmd
+}
+
+func listProfiles(cmd *cobra.Command, args []string) {
+	if err := loadConfig(appConfig.CfgFile); err != nil {
+		fmt.Printf("Error loading config | 配置加载错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	names := profileNames()
+	if len(names) == 0 {
+		fmt.Println("No profiles configured | 未配置 profile")
+		return
+	}
+	fmt.Println("Profiles | 配置 profile:")
+	for _, name := range names {
+		if name == activeProfile {
+			fmt.Printf("  * %s (active, %s | 当前)\n", name, activeProfileSource)
+		} else {
+			fmt.Printf("    %s\n", name)
+		}
+	}
+}
+
+func useProfile(cmd *cobra.Command, args []string) {
+	none, _ := cmd.Flags().GetBool("none")
+	if none == (len(args) == 1) {
+		fmt.Println("Usage | 用法: sse profile use <profile> | sse profile use --none")
+		os.Exit(1)
+	}
+
+	// 用新的 profile 加载配置以校验它，保存的旧 profile 已失效时也能切换
+	var name string
+	if !none {
+		name = args[0]
+		appConfig.Profile = name
+		if err := loadConfig(appConfig.CfgFile); err != nil {
+			fmt.Printf("Error loading config | 配置加载错误: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	err := updateConfigFile(func(root *yaml.Node) error {
+		if name == "" {
+			removeKey(root, "profile")
+		} else {
+			setScalar(root, "profile", name)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Error saving config | 配置保存错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	if name == "" {
+		fmt.Println("✅ No profile in use | 已停止使用 profile")
+	} else {
+		fmt.Printf("✅ Active profile | 当前 profile: %s\n", name)
+	}
+	if os.Getenv("SSE_PROFILE") != "" {
+		fmt.Printf("⚠️  SSE_PROFILE=%s still takes precedence | SSE_PROFILE 仍然优先\n", os.Getenv("SSE_PROFILE"))
+	}
+}
+
+func showProfile(cmd *cobra.Command, args []string) {
+	if err := loadConfig(appConfig.CfgFile); err != nil {
+		fmt.Printf("Error loading config | 配置加载错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	name := activeProfile
+	if len(args) == 1 {
+		name = args[0]
+	}
+	if name == "" {
+		fmt.Println("No profile in use | 未使用 profile")
+		return
+	}
+	profile, exists := config.Profiles[name]
+	if !exists {
+		fmt.Printf("Profile not found | profile 未找到: %s (available | 可用: %s)\n", name, strings.Join(profileNames(), ", "))
+		os.Exit(1)
+	}
+
+	if reveal, _ := cmd.Flags().GetBool("reveal"); !reveal {
+		profile = maskProfileSecrets(profile)
+	}
+	data, err := yaml.Marshal(profile)
+	if err != nil {
+		fmt.Printf("Error | 错误: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Profile | 配置 profile: %s\n", name)
+	if name == activeProfile {
+		fmt.Printf("Active | 当前使用 (%s)\n", activeProfileSource)
+	}
+	fmt.Println()
+	fmt.Print(string(data))
+}
@@ -3,9 +3,11 @@ package internal
 import (
 	"fmt"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
 
+	"sse-client/providers"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -16,98 +18,232 @@ type Config struct {
 	Temperature     float64                   `yaml:"temperature"`
 	DefaultProvider string                    `yaml:"default_provider"`
 	DefaultModel    string                    `yaml:"default_model"`
+	Retry           *RetryConfig              `yaml:"retry,omitempty"`
+	Fallback        []string                  `yaml:"fallback,omitempty"` // 备用链，如 "bailian/qwen-max -> openai/gpt-4o-mini"
+	Routes          []RouteRule               `yaml:"routes,omitempty"`   // 按顺序匹配的模型路由规则，优先于内置规则
+	Aliases         map[string]Alias          `yaml:"aliases,omitempty"`  // 模型别名，如 coder -> bailian/qwen2.5-coder-32b-instruct
+	System          string                    `yaml:"system,omitempty"`   // 默认系统提示词，别名中的 system 优先
+	// AllowedProviders 限制可以使用的 provider（包括备用链），为空时不限制；通常写在项目的 .sse.yaml 中
+	AllowedProviders []string `yaml:"allowed_providers,omitempty"`
+	// TrustedProjects 是允许读取 API key 等敏感字段的项目目录，只从用户和系统配置中读取
+	TrustedProjects []string `yaml:"trusted_projects,omitempty"`
+	// Profiles 是命名的配置覆盖（如公司账号、个人账号），Profile 是 sse profile use 保存的当前 profile
+	Profiles map[string]map[string]interface{} `yaml:"profiles,omitempty"`
+	Profile  string                            `yaml:"profile,omitempty"`
 }
 
 type ProviderConfig struct {
-	BaseURL string   `yaml:"base_url"`
-	APIKey  string   `yaml:"api_key"`
-	Models  []string `yaml:"models"`
+	// Type 是 provider 使用的 API 格式：openai（或 openai-compatible）、azure、anthropic、google、vertex、bailian、deepseek、ollama。
+	// 内置 provider 默认使用同名类型，自定义 provider 默认使用 openai
+	Type       string            `yaml:"type,omitempty"`
+	BaseURL    string            `yaml:"base_url"`
+	APIKey     string            `yaml:"api_key"`                // 可以引用环境变量，如 "${OPENAI_KEY}"
+	APIKeyCmd  string            `yaml:"api_key_cmd,omitempty"`  // 输出 API key 的命令，如 "pass show bailian"，优先于 api_key_file 和 api_key
+	APIKeyFile string            `yaml:"api_key_file,omitempty"` // 保存 API key 的文件，优先于 api_key
+	AuthHeader string            `yaml:"auth_header,omitempty"`  // 携带 API key 的请求头，如 api-key
+	AuthScheme string            `yaml:"auth_scheme,omitempty"`  // API key 前的认证方案，如 Bearer；"none" 表示直接发送 key
+	Headers    map[string]string `yaml:"headers,omitempty"`      // 额外的请求头
+	APIVersion string            `yaml:"api_version,omitempty"`  // Azure OpenAI 的 api-version
+	// Vertex AI 的服务账号凭据、项目和区域；credentials_file 为空时使用 GOOGLE_APPLICATION_CREDENTIALS
+	CredentialsFile string                  `yaml:"credentials_file,omitempty"`
+	Project         string                  `yaml:"project,omitempty"`
+	Location        string                  `yaml:"location,omitempty"`
+	TokenURL        string                  `yaml:"token_url,omitempty"` // OAuth2 令牌端点，可替换为本地测试服务
+	Models          []string                `yaml:"models"`
+	ModelOptions    map[string]ModelOptions `yaml:"model_options,omitempty"`
+	Retry           *RetryConfig            `yaml:"retry,omitempty"` // 覆盖全局 retry 中设置了的字段
+}
+
+// ModelOptions 是按模型设置的默认请求参数，命令行参数优先
+type ModelOptions struct {
+	Reasoning  string                 `yaml:"reasoning,omitempty"`  // 默认推理强度：off、low、medium、high
+	Deployment string                 `yaml:"deployment,omitempty"` // Azure OpenAI 中该模型的部署名，未设置时使用模型名
+	API        string                 `yaml:"api,omitempty"`        // OpenAI 模型使用的接口：chat（默认）或 responses
+	ExtraBody  map[string]interface{} `yaml:"extra_body,omitempty"` // 合并到请求体中的额外字段，别名中的同名字段优先
 }
 
 var config *Config
 
-func loadConfig(configFile string) error {
-	// 初始化默认配置
-	config = &Config{
-		Providers:   make(map[string]ProviderConfig),
-		Timeout:     30,
-		MaxTokens:   4096,
-		Temperature: 0.7,
+// builtinProviders 是内置的 provider，按显示顺序排列；它们不需要在 config.yaml 中声明即可通过环境变量配置
+var builtinProviders = []string{"bailian", "openai", "google", "anthropic", "deepseek", "ollama"}
+
+// providerTypes 是 config.yaml 中 type 字段可用的取值
+var providerTypes = []string{"openai", "openai-compatible", "azure", "anthropic", "google", "vertex", "bailian", "deepseek", "ollama"}
+
+// keylessProviderTypes 是不需要 API key 的 API 格式（本地服务）
+var keylessProviderTypes = []string{"ollama"}
+
+// hasCredentials 判断 provider 是否具备认证信息：本地服务不需要，Vertex AI 需要服务账号凭据，其余需要 API key
+func hasCredentials(name string, cfg ProviderConfig) bool {
+	switch t := providerType(name, cfg); {
+	case containsString(keylessProviderTypes, t):
+		return true
+	case t == "vertex":
+		return credentialsFile(cfg) != ""
+	default:
+		// api_key_cmd / api_key_file 在使用时才解析，这里只检查是否配置
+		return cfg.APIKey != "" || cfg.APIKeyCmd != "" || cfg.APIKeyFile != ""
 	}
+}
 
-	// 确定配置文件路径
-	configPath := findConfigFile(configFile)
+// credentialsFile 返回 Vertex AI 的服务账号凭据文件，未配置时使用 GOOGLE_APPLICATION_CREDENTIALS
+func credentialsFile(cfg ProviderConfig) string {
+	if cfg.CredentialsFile != "" {
+		return cfg.CredentialsFile
+	}
+	return os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+}
 
-	// 尝试读取配置文件
-	if configPath != "" {
-		if data, err := os.ReadFile(configPath); err == nil {
-			if err := yaml.Unmarshal(data, config); err != nil {
-				return fmt.Errorf("failed to parse config file %s: %v", configPath, err)
-			}
+// providerType 返回 provider 使用的 API 格式
+func providerType(name string, cfg ProviderConfig) string {
+	if cfg.Type != "" {
+		if cfg.Type == "openai-compatible" {
+			return "openai"
 		}
+		return cfg.Type
 	}
+	for _, builtin := range builtinProviders {
+		if builtin == name {
+			return name
+		}
+	}
+	return "openai"
+}
 
-	// 从环境变量加载配置，覆盖文件配置
-	loadFromEnvironment()
+// providerNames 返回所有 provider 名称：内置 provider 在前，config.yaml 中自定义的 provider 按名称排序在后
+func providerNames() []string {
+	names := append([]string{}, builtinProviders...)
+	var custom []string
+	if config != nil {
+		for name := range config.Providers {
+			if !containsString(builtinProviders, name) {
+				custom = append(custom, name)
+			}
+		}
+	}
+	sort.Strings(custom)
+	return append(names, custom...)
+}
 
-	// 确保所有provider都有默认模型配置
-	ensureDefaultModels()
+// configuredProviderNames 返回 providerNames 中已出现在配置里的 provider
+func configuredProviderNames() []string {
+	var names []string
+	for _, name := range providerNames() {
+		if _, exists := getProviderConfig(name); exists {
+			names = append(names, name)
+		}
+	}
+	return names
+}
 
+// validateProviderTypes 检查所有 provider 的 type 字段
+func validateProviderTypes() error {
+	for name, cfg := range config.Providers {
+		if cfg.Type != "" && !containsString(providerTypes, cfg.Type) {
+			return fmt.Errorf("provider '%s' has unknown type '%s' (expected: %s)", name, cfg.Type, strings.Join(providerTypes, ", "))
+		}
+		// Responses API 只有 OpenAI 格式的 provider 支持
+		for model, options := range cfg.ModelOptions {
+			switch options.API {
+			case "", providers.APIChat:
+			case providers.APIResponses:
+				if providerType(name, cfg) != "openai" {
+					return fmt.Errorf("%s.model_options.%s: api 'responses' is only supported by openai providers", name, model)
+				}
+			default:
+				return fmt.Errorf("%s.model_options.%s: unknown api '%s' (expected: chat, responses)", name, model, options.API)
+			}
+		}
+	}
 	return nil
 }
 
-// 查找配置文件
-func findConfigFile(specifiedFile string) string {
-	// 如果用户指定了配置文件，直接使用
-	if specifiedFile != "" {
-		if _, err := os.Stat(specifiedFile); err == nil {
-			return specifiedFile
+// usesResponsesAPI 判断 provider 的模型是否配置为使用 OpenAI Responses API
+func usesResponsesAPI(providerName, model string) bool {
+	cfg, exists := getProviderConfig(providerName)
+	return exists && cfg.ModelOptions[model].API == providers.APIResponses
+}
+
+// providerAllowed 判断 allowed_providers 是否允许使用该 provider
+func providerAllowed(providerName string) bool {
+	return config == nil || len(config.AllowedProviders) == 0 || containsString(config.AllowedProviders, providerName)
+}
+
+// validateAllowedProviders 检查 allowed_providers 中的 provider 都存在
+func validateAllowedProviders(allowed []string) error {
+	for _, name := range allowed {
+		if !containsString(providerNames(), name) {
+			return fmt.Errorf("allowed_providers: unknown provider '%s' (available: %s)", name, strings.Join(providerNames(), ", "))
 		}
-		return ""
 	}
+	return nil
+}
 
-	// 获取可执行文件路径
-	execPath, err := os.Executable()
-	var execDir string
-	if err == nil {
-		execDir = filepath.Dir(execPath)
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
 	}
+	return false
+}
 
-	// 按优先级搜索配置文件
-	searchPaths := []string{
-		"./config.yaml", // 当前目录
-		os.ExpandEnv("$HOME/.config/sse-client/config.yaml"), // 用户配置目录
-		"/etc/sse-client/config.yaml",                        // 系统配置目录
+// loadConfig 按优先级从低到高合并各层配置文件（见 configLayers）和选中的 profile，再用环境变量覆盖 provider 的 API key 和 base URL
+func loadConfig(configFile string) error {
+	// 初始化默认配置
+	config = &Config{
+		Providers:   make(map[string]ProviderConfig),
+		Timeout:     defaultTimeout,
+		MaxTokens:   defaultMaxTokens,
+		Temperature: defaultTemperature,
 	}
+	configSources = make(map[string]string)
+	resolvedProviders = make(map[string]bool)
 
-	// 如果能获取到可执行文件目录，添加相对于可执行文件的配置路径
-	if execDir != "" {
-		// 添加可执行文件同目录下的sse-configs目录（新的命名规范）
-		execConfigPaths := []string{
-			filepath.Join(execDir, "config.yaml"),
-			filepath.Join(execDir, "sse-configs", "config.yaml"),   // 新的配置目录
-			filepath.Join(execDir, "configs", "config.yaml"),       // 兼容旧的配置目录
-			filepath.Join(execDir, "..", "configs", "config.yaml"), // 用于开发环境
-		}
-		// 将可执行文件相关路径插入到搜索路径的前面
-		searchPaths = append(execConfigPaths, searchPaths...)
+	merged, err := readConfigLayers(configLayers(configFile))
+	if err != nil {
+		return err
+	}
+	if err := applyProfile(merged); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("failed to merge config files: %v", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("failed to parse merged config: %v", err)
 	}
 
-	for _, path := range searchPaths {
-		if _, err := os.Stat(path); err == nil {
-			return path
-		}
+	if err := validateProviderTypes(); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	if err := validateFallbackChains(config.Fallback); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	if err := validateRoutes(config.Routes); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	if err := validateAliases(config.Aliases); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	if err := validateAllowedProviders(config.AllowedProviders); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
 	}
 
-	return "" // 没有找到配置文件，将使用环境变量和默认值
+	// 从环境变量加载配置，覆盖文件配置
+	loadFromEnvironment()
+
+	// 确保所有provider都有默认模型配置
+	ensureDefaultModels()
+
+	return nil
 }
 
-// 从环境变量加载配置
+// 从环境变量加载配置：内置 provider 和 config.yaml 中声明的 provider 都读取 <NAME>_API_KEY / <NAME>_BASE_URL
 func loadFromEnvironment() {
-	providers := []string{"bailian", "openai", "google", "anthropic", "deepseek"}
-
-	for _, provider := range providers {
-		providerUpper := strings.ToUpper(provider)
+	for _, provider := range providerNames() {
+		providerUpper := providers.EnvPrefix(provider)
 
 		// 获取环境变量
 		apiKey := os.Getenv(providerUpper + "_API_KEY")
@@ -130,6 +266,7 @@ func loadFromEnvironment() {
 			// 环境变量优先级更高，但保留现有的模型配置
 			if apiKey != "" {
 				existingConfig.APIKey = apiKey
+				existingConfig.APIKeyCmd, existingConfig.APIKeyFile = "", ""
 			}
 			if baseURL != "" {
 				existingConfig.BaseURL = baseURL
@@ -185,36 +322,19 @@ func addModelToConfig(providerName, modelName string) error {
 	providerCfg.Models = append(providerCfg.Models, modelName)
 	config.Providers[providerName] = providerCfg
 
-	// 保存配置到文件
-	return saveConfig()
-}
-
-func saveConfig() error {
-	// 确定配置文件路径 - 使用与 loadConfig 相同的逻辑
-	configPath := findConfigFile(appConfig.CfgFile)
-	if configPath == "" {
-		// 如果没有找到现有配置文件，使用默认路径
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return fmt.Errorf("cannot get home directory: %v", err)
+	// 只写入目标配置文件：在该文件的 models 列表末尾追加
+	return updateConfigFile(func(root *yaml.Node) error {
+		providersNode := mappingValue(root, "providers", yaml.MappingNode)
+		providerNode := mappingValue(providersNode, providerName, yaml.MappingNode)
+		models := mappingValue(providerNode, "models", yaml.SequenceNode)
+		if models.Kind != yaml.SequenceNode {
+			return fmt.Errorf("%s.models in %s is not a list", providerName, saveTarget())
 		}
-		configDir := filepath.Join(homeDir, ".config", "sse-client")
-		configPath = filepath.Join(configDir, "config.yaml")
-
-		// 确保目录存在
-		if err := os.MkdirAll(configDir, 0755); err != nil {
-			return fmt.Errorf("cannot create config directory: %v", err)
-		}
-	}
-
-	// 将配置写入 YAML 文件
-	data, err := yaml.Marshal(&config)
-	if err != nil {
-		return fmt.Errorf("cannot marshal config: %v", err)
-	}
-
-	return os.WriteFile(configPath, data, 0644)
+		models.Content = append(models.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: modelName})
+		return nil
+	})
 }
+
 func setDefaultProvider(provider, model string) error {
 	// 确保配置已加载
 	if config == nil {
@@ -244,8 +364,12 @@ func setDefaultProvider(provider, model string) error {
 	config.DefaultProvider = provider
 	config.DefaultModel = model
 
-	// 保存配置
-	return saveConfig()
+	// 只写入目标配置文件
+	return updateConfigFile(func(root *yaml.Node) error {
+		setScalar(root, "default_provider", provider)
+		setScalar(root, "default_model", model)
+		return nil
+	})
 }
 
 func getDefaultProvider() (string, string) {
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// fallbackTarget 是备用链中的一个 provider/model
+type fallbackTarget struct {
+	provider string
+	model    string
+}
+
+func (t fallbackTarget) String() string {
+	return t.provider + "/" + t.model
+}
+
+// parseFallbackChain 解析形如 "bailian/qwen-max -> deepseek/deepseek-v3 -> openai/gpt-4o-mini" 的备用链；
+// 模型名本身可以包含 "/"，只按第一个 "/" 拆分
+func parseFallbackChain(chain string) ([]fallbackTarget, error) {
+	var targets []fallbackTarget
+	for _, entry := range strings.Split(chain, "->") {
+		entry = strings.TrimSpace(entry)
+		provider, model, ok := strings.Cut(entry, "/")
+		if !ok || provider == "" || model == "" {
+			return nil, fmt.Errorf("invalid fallback entry '%s' in chain '%s' (expected provider/model)", entry, chain)
+		}
+		targets = append(targets, fallbackTarget{provider: provider, model: model})
+	}
+	if len(targets) < 2 {
+		return nil, fmt.Errorf("fallback chain '%s' needs at least two entries", chain)
+	}
+	return targets, nil
+}
+
+// validateFallbackChains 在加载配置时检查所有备用链的格式
+func validateFallbackChains(chains []string) error {
+	for _, chain := range chains {
+		if _, err := parseFallbackChain(chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fallbackChain 返回 provider/model 失败后依次尝试的备用目标：
+// 使用第一条包含该 provider/model 的备用链中位于它之后的部分
+func fallbackChain(providerName, model string) []fallbackTarget {
+	if config == nil {
+		return nil
+	}
+	for _, chain := range config.Fallback {
+		targets, err := parseFallbackChain(chain)
+		if err != nil {
+			continue
+		}
+		for i, target := range targets {
+			if target.provider == providerName && target.model == model {
+				return targets[i+1:]
+			}
+		}
+	}
+	return nil
+}
+
+// shouldFallback 判断错误是否应切换到备用目标：可重试的临时错误（429 / 5xx）或连接错误
+func shouldFallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if shouldRetry(err) {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
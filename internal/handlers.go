@@ -2,23 +2,58 @@ package internal
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+
+	"sse-client/providers"
 )
 
+// exitCodeInterrupted 是请求被 Ctrl-C 中断时的退出码（与 shell 的 128+SIGINT 约定一致）
+const exitCodeInterrupted = 130
+
+// exitCodeInvalidOutput 是 --json / --json-schema 模式下修复重试后输出仍未通过校验时的退出码
+const exitCodeInvalidOutput = 2
+
 // AppConfig 保存应用程序配置参数
 type AppConfig struct {
 	CfgFile     string
+	Profile     string // --profile 使用的 profile，优先于 SSE_PROFILE 和配置文件中的 profile
 	Temperature float64
 	MaxTokens   int
-	Timeout     int
-	ImagePath   string
-	FilePath    string
-	EditPath    string
-	ExecuteMode bool
-	CommandMode bool
+	// TemperatureSet / MaxTokensSet / TimeoutSet 表示命令行显式设置了 -t / -m / --timeout，
+	// 只有显式设置的参数才覆盖配置文件、别名和环境变量中的值
+	TemperatureSet bool
+	MaxTokensSet   bool
+	TimeoutSet     bool
+	Timeout        int
+	ImagePath      string
+	ImageDetail    string
+	FilePath       string
+	EditPath       string
+	ExecuteMode    bool
+	CommandMode    bool
+	ShowUsage      bool
+	// 对话模式下将推理过程输出到 stderr；命令模式和编辑模式只使用回答文本，推理内容不会被解析或写入文件
+	ShowReasoning  bool
+	Reasoning      string // --reasoning 推理强度，为空时使用 model_options 或模型默认值
+	JSONMode       bool   // --json：只输出 JSON
+	JSONSchemaPath string // --json-schema：输出需符合的 JSON Schema 文件，隐含 --json
+	ContinueID     string // --continue：在服务端保存的上一次响应之后继续对话（Responses API）
+
+	fileContent string                 // -f 文件读取后的内容
+	jsonSchema  *jsonSchema            // --json-schema 解析后的 schema
+	schemaRaw   []byte                 // --json-schema 文件的原始内容，随请求发送
+	alias       *Alias                 // 命令行中使用的别名
+	aliasName   string                 // 别名名称，用于显示参数来源
+	system      string                 // 别名中的系统提示词
+	extraBody   map[string]interface{} // 别名中的额外请求体字段
 }
 
 // 全局配置实例
@@ -38,14 +73,60 @@ func HandleSSE(args []string) {
 		os.Exit(1)
 	}
 
+	if err := validateImageDetail(appConfig.ImageDetail); err != nil {
+		fmt.Printf("Error | 错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	reasoning, err := providers.ParseReasoningLevel(appConfig.Reasoning)
+	if err != nil {
+		fmt.Printf("Error | 错误: %v\n", err)
+		os.Exit(1)
+	}
+	appConfig.Reasoning = string(reasoning)
+
+	if appConfig.JSONSchemaPath != "" {
+		schema, raw, err := loadJSONSchema(appConfig.JSONSchemaPath)
+		if err != nil {
+			fmt.Printf("Error | 错误: %v\n", err)
+			os.Exit(1)
+		}
+		appConfig.JSONMode = true
+		appConfig.jsonSchema = schema
+		appConfig.schemaRaw = raw
+	}
+	if appConfig.JSONMode && (appConfig.CommandMode || appConfig.EditPath != "") {
+		fmt.Printf("Error | 错误: --json cannot be combined with -c or -e | --json 不能与 -c 或 -e 同时使用\n")
+		os.Exit(1)
+	}
+
 	// 检查是否有 stdin 输入（管道输入）
 	stdinData := readStdinIfAvailable()
 
-	// 解析参数
+	// 解析参数，别名在路由之前展开为 provider/model 并填充默认参数
 	provider, model, message = parseArgs(args, stdinData)
 
+	// provider/model 形式的模型参数（如 openrouter/anthropic/claude-3.5）明确指定了 provider
+	if provider == "" {
+		if p, m, ok := splitProviderModel(model); ok {
+			provider, model = p, m
+		}
+	}
+
+	// 按优先级确定 timeout / max_tokens / temperature 的生效值
+	timeout, maxTokens, temperature, _, err := resolveSettings(appConfig.alias, appConfig.aliasName)
+	if err != nil {
+		fmt.Printf("Error | 错误: %v\n", err)
+		os.Exit(1)
+	}
+	appConfig.Timeout, appConfig.MaxTokens, appConfig.Temperature = timeout, maxTokens, temperature
+
 	client := NewSSEClient()
 
+	// Ctrl-C / SIGTERM 取消正在进行的请求，而不是直接杀死进程
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// 处理文件输入
 	if appConfig.FilePath != "" {
 		fileContent, err := readFileContent(appConfig.FilePath)
@@ -53,14 +134,15 @@ func HandleSSE(args []string) {
 			fmt.Printf("Error reading file | 文件读取错误: %v\n", err)
 			os.Exit(1)
 		}
-		// 将文件内容添加到消息中
-		message = message + "\n\n文件内容:\n" + fileContent
+		// 文件内容作为单独的内容片段随消息发送
+		appConfig.fileContent = fileContent
 	}
 
 	// 处理文件编辑
 	if appConfig.EditPath != "" {
-		err := handleFileEdit(client, provider, model, appConfig.EditPath, message, appConfig.ImagePath, appConfig.Temperature, appConfig.MaxTokens, appConfig.Timeout)
+		err := handleFileEdit(ctx, client, provider, model, appConfig.EditPath, message)
 		if err != nil {
+			exitOnInterrupt(err, stop)
 			fmt.Printf("Error editing file | 文件编辑错误: %v\n", err)
 			os.Exit(1)
 		}
@@ -68,27 +150,58 @@ func HandleSSE(args []string) {
 	}
 
 	// 根据模式处理
-	var err error
 	if appConfig.CommandMode {
 		// 命令模式：生成或执行命令
 		if appConfig.ExecuteMode {
 			// -c -y: 命令模式 + 直接执行
-			err = handleCommandExecution(client, provider, model, message, appConfig.ImagePath, appConfig.Temperature, appConfig.MaxTokens, appConfig.Timeout)
+			err = handleCommandExecution(ctx, client, provider, model, message)
 		} else {
 			// -c: 命令模式，只输出命令
-			err = handleCommandOutput(client, provider, model, message, appConfig.ImagePath, appConfig.Temperature, appConfig.MaxTokens, appConfig.Timeout)
+			err = handleCommandOutput(ctx, client, provider, model, message)
 		}
+	} else if appConfig.JSONMode {
+		// 结构化输出模式：只输出通过校验的 JSON
+		err = handleStructuredOutput(ctx, client, provider, model, message)
 	} else {
 		// 普通对话模式（默认）
-		err = handleNormalConversation(client, provider, model, message, appConfig.ImagePath, appConfig.Temperature, appConfig.MaxTokens, appConfig.Timeout)
+		err = handleNormalConversation(ctx, client, provider, model, message)
 	}
 
 	if err != nil {
+		exitOnInterrupt(err, stop)
+		var invalid *invalidOutputError
+		if errors.As(err, &invalid) {
+			fmt.Fprintf(os.Stderr, "Error | 错误: %v\n", err)
+			os.Exit(exitCodeInvalidOutput)
+		}
 		fmt.Printf("Error | 错误: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// exitOnInterrupt 在请求被用户中断时提示并以 exitCodeInterrupted 退出
+func exitOnInterrupt(err error, stop context.CancelFunc) {
+	if !errors.Is(err, context.Canceled) {
+		return
+	}
+	stop()
+	fmt.Fprintln(os.Stderr, "\n⚠️  Interrupted | 已中断")
+	os.Exit(exitCodeInterrupted)
+}
+
+// validateImageDetail 检查 --image-detail 的取值
+func validateImageDetail(detail string) error {
+	if detail == "" {
+		return nil
+	}
+	for _, d := range providers.ImageDetails {
+		if d == detail {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --image-detail value '%s' (expected: %s)", detail, strings.Join(providers.ImageDetails, ", "))
+}
+
 // 解析命令行参数
 func parseArgs(args []string, stdinData string) (provider, model, message string) {
 	if len(args) == 0 {
@@ -131,6 +244,11 @@ func parseArgs(args []string, stdinData string) (provider, model, message string
 		} else {
 			message = args[1]
 		}
+		// 别名展开为对应的 provider/model，并使用别名中的默认参数
+		if alias, exists := lookupAlias(model); exists {
+			applyAlias(model, alias)
+			provider, model = alias.Provider, alias.Model
+		}
 	} else if len(args) == 3 {
 		// Format: sse [provider] [model] [message] or command | sse [provider] [model] [additional_message]
 		provider = args[0]
@@ -171,12 +289,12 @@ func readStdinIfAvailable() string {
 }
 
 // handleCommandOutput 处理命令输出模式（默认行为：只输出命令，不执行）
-func handleCommandOutput(client *SSEClient, provider, model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
+func handleCommandOutput(ctx context.Context, client *SSEClient, provider, model, message string) error {
 	// 修改提示词以获得纯命令输出
 	modifiedMessage := message + "\n\n重要：请只返回可以直接执行的命令行命令，不要包含任何解释文字、描述或说明。每个命令单独一行。不要使用代码块格式。请确保命令在 macOS 和 Linux 系统上都能正常工作。\n\nIMPORTANT: Only return executable command line commands without any explanations, descriptions, or commentary. One command per line. Do not use code block formatting. Ensure commands work on both macOS and Linux systems."
 
 	// 获取完整的AI响应（非流式）
-	response, err := getFullResponse(client, provider, model, modifiedMessage, imagePath, temperature, maxTokens, timeout)
+	response, err := getFullResponse(ctx, client, provider, newChatRequest(model, modifiedMessage))
 	if err != nil {
 		return err
 	}
@@ -194,12 +312,12 @@ func handleCommandOutput(client *SSEClient, provider, model, message, imagePath
 }
 
 // handleCommandExecution 处理命令执行模式（-y 参数：获取命令并直接执行）
-func handleCommandExecution(client *SSEClient, provider, model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
+func handleCommandExecution(ctx context.Context, client *SSEClient, provider, model, message string) error {
 	// 修改提示词以获得纯命令输出
 	modifiedMessage := message + "\n\n重要：请只返回可以直接执行的命令行命令，不要包含任何解释文字、描述或说明。每个命令单独一行。不要使用代码块格式。请确保命令在 macOS 和 Linux 系统上都能正常工作。\n\nIMPORTANT: Only return executable command line commands without any explanations, descriptions, or commentary. One command per line. Do not use code block formatting. Ensure commands work on both macOS and Linux systems."
 
 	// 获取完整的AI响应（非流式）
-	response, err := getFullResponse(client, provider, model, modifiedMessage, imagePath, temperature, maxTokens, timeout)
+	response, err := getFullResponse(ctx, client, provider, newChatRequest(model, modifiedMessage))
 	if err != nil {
 		return err
 	}
@@ -214,10 +332,15 @@ func handleCommandExecution(client *SSEClient, provider, model, message, imagePa
 
 	// 执行每个命令
 	for _, cmd := range commands {
+		// 中断后不再执行剩余命令
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		fmt.Printf("🚀 Executing: %s\n", cmd)
 
 		// 使用 bash 执行命令
-		execCmd := exec.Command("bash", "-c", cmd)
+		execCmd := exec.CommandContext(ctx, "bash", "-c", cmd)
 		execCmd.Stdout = os.Stdout
 		execCmd.Stderr = os.Stderr
 
@@ -233,13 +356,95 @@ func handleCommandExecution(client *SSEClient, provider, model, message, imagePa
 }
 
 // handleNormalConversation 处理普通对话模式（默认模式：纯对话，不生成命令）
-func handleNormalConversation(client *SSEClient, provider, model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
+func handleNormalConversation(ctx context.Context, client *SSEClient, provider, model, message string) error {
 	// 直接使用流式响应进行对话，不修改消息内容
-	return client.StreamWithProvider(provider, model, message, imagePath, temperature, maxTokens, timeout)
+	renderer := newStreamRenderer()
+	response, err := client.StreamWithProvider(ctx, provider, newChatRequest(model, message), renderer.Handle)
+	renderer.Finish()
+	if response != nil {
+		printUsage(response.Usage)
+		printResponseID(response.ID)
+	}
+	return err
+}
+
+// printResponseID 在 stderr 输出服务端保存的响应 ID，供 --continue 继续对话
+func printResponseID(id string) {
+	if id == "" {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "🔗 Response ID: %s (continue with --continue %s) | 使用 --continue 继续对话\n", id, id)
+}
+
+// invalidOutputError 表示结构化输出在修复重试后仍未通过校验
+type invalidOutputError struct {
+	problems []string
+}
+
+func (e *invalidOutputError) Error() string {
+	return fmt.Sprintf("model output is not valid JSON for the schema | 模型输出未通过 JSON 校验:\n  %s", strings.Join(e.problems, "\n  "))
+}
+
+// handleStructuredOutput 处理 --json / --json-schema 模式：获取完整响应并在本地校验，
+// 校验失败时把错误反馈给模型修复一次，仍失败则返回 invalidOutputError
+func handleStructuredOutput(ctx context.Context, client *SSEClient, provider, model, message string) error {
+	instruction := "Respond with only a single JSON value, without code fences or any other text."
+	if appConfig.schemaRaw != nil {
+		instruction += "\nThe JSON must conform to this JSON Schema:\n" + string(appConfig.schemaRaw)
+	}
+
+	req := newChatRequest(model, message)
+	req.Messages = append([]providers.Message{
+		providers.NewMessage(providers.RoleSystem, providers.TextPart(instruction)),
+	}, req.Messages...)
+
+	output, err := getFullResponse(ctx, client, provider, req)
+	if err != nil {
+		return err
+	}
+	output = stripCodeFence(output)
+
+	problems := appConfig.jsonSchema.Validate(output)
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "⚠️  Output failed validation, retrying once | 输出未通过校验，重试一次: %s\n", problems[0])
+
+		repair := fmt.Sprintf("Your previous response did not pass validation:\n- %s\n\nReply again with only the corrected JSON.", strings.Join(problems, "\n- "))
+		req.Messages = append(req.Messages,
+			providers.NewMessage(providers.RoleAssistant, providers.TextPart(output)),
+			providers.NewMessage(providers.RoleUser, providers.TextPart(repair)),
+		)
+
+		output, err = getFullResponse(ctx, client, provider, req)
+		if err != nil {
+			return err
+		}
+		output = stripCodeFence(output)
+
+		if problems = appConfig.jsonSchema.Validate(output); len(problems) > 0 {
+			return &invalidOutputError{problems: problems}
+		}
+	}
+
+	fmt.Println(output)
+	return nil
+}
+
+// stripCodeFence 去掉模型回答外层的 ``` 代码块标记
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	if newline := strings.Index(text, "\n"); newline >= 0 {
+		text = text[newline+1:]
+	} else {
+		text = strings.TrimPrefix(text, "```")
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
 }
 
 // handleFileEdit 处理文件编辑模式（读取文件，根据指令修改，写回文件）
-func handleFileEdit(client *SSEClient, provider, model, filePath, instruction, imagePath string, temperature float64, maxTokens, timeout int) error {
+func handleFileEdit(ctx context.Context, client *SSEClient, provider, model, filePath, instruction string) error {
 	// 读取文件内容，如果文件不存在则创建空文件
 	var originalContent string
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -269,13 +474,18 @@ func handleFileEdit(client *SSEClient, provider, model, filePath, instruction, i
 	}
 
 	// 获取AI的完整响应
-	newContent, err := getFullResponse(client, provider, model, editPrompt, imagePath, temperature, maxTokens, timeout)
+	newContent, err := getFullResponse(ctx, client, provider, newChatRequest(model, editPrompt))
 	if err != nil {
-		return fmt.Errorf("failed to get AI response: %v", err)
+		return fmt.Errorf("failed to get AI response: %w", err)
+	}
+
+	// 响应结束后才被中断时同样放弃写入，保证不会留下半成品文件
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	// 写入文件
-	err = os.WriteFile(filePath, []byte(newContent), 0644)
+	err = writeFileAtomic(filePath, []byte(newContent), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write file: %v", err)
 	}
@@ -285,9 +495,56 @@ func handleFileEdit(client *SSEClient, provider, model, filePath, instruction, i
 }
 
 // getFullResponse 获取完整的AI响应（非流式）
-func getFullResponse(client *SSEClient, provider, model, message, imagePath string, temperature float64, maxTokens, timeout int) (string, error) {
+func getFullResponse(ctx context.Context, client *SSEClient, provider string, req *providers.ChatRequest) (string, error) {
 	// 使用新的 GetFullResponseWithProvider 方法获取完整响应
-	return client.GetFullResponseWithProvider(provider, model, message, imagePath, temperature, maxTokens, timeout)
+	response, err := client.GetFullResponseWithProvider(ctx, provider, req)
+	if response != nil {
+		printUsage(response.Usage)
+	}
+	if err != nil {
+		return "", err
+	}
+	return response.Text, nil
+}
+
+// newChatRequest 根据用户消息和命令行参数（-f 文件、-i 图片）构建对话请求
+func newChatRequest(model, message string) *providers.ChatRequest {
+	parts := []providers.ContentPart{providers.TextPart(message)}
+	if appConfig.fileContent != "" {
+		parts = append(parts, providers.FilePart(filepath.Base(appConfig.FilePath), appConfig.fileContent))
+	}
+	if appConfig.ImagePath != "" {
+		image := providers.ImagePart(appConfig.ImagePath)
+		image.Detail = appConfig.ImageDetail
+		parts = append(parts, image)
+	}
+
+	req := &providers.ChatRequest{
+		Model: model,
+		Messages: []providers.Message{
+			providers.NewMessage(providers.RoleUser, parts...),
+		},
+		Temperature: appConfig.Temperature,
+		MaxTokens:   appConfig.MaxTokens,
+		Timeout:     appConfig.Timeout,
+		Reasoning:   providers.ReasoningLevel(appConfig.Reasoning),
+
+		PreviousResponseID: appConfig.ContinueID,
+		ExtraBody:          appConfig.extraBody,
+	}
+	system := appConfig.system
+	if system == "" {
+		system = config.System
+	}
+	if system != "" {
+		req.Messages = append([]providers.Message{
+			providers.NewMessage(providers.RoleSystem, providers.TextPart(system)),
+		}, req.Messages...)
+	}
+	if appConfig.JSONMode {
+		req.ResponseFormat = &providers.ResponseFormat{Schema: appConfig.schemaRaw}
+	}
+	return req
 }
 
 // readFileContent 读取文件内容
@@ -305,3 +562,29 @@ func readFileContent(filePath string) (string, error) {
 
 	return string(content), nil
 }
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，避免中途失败留下不完整的文件
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(filePath); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filePath)
+}
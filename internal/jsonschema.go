@@ -0,0 +1,335 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// jsonSchema 是本地校验用的 JSON Schema，只实现结构化输出常用的关键字：
+// type、enum、const、properties、required、additionalProperties、items、
+// min/maxItems、min/maxLength、pattern、minimum/maximum、exclusiveMinimum/Maximum、
+// allOf/anyOf/oneOf，以及指向 #/$defs、#/definitions 的本地 $ref
+type jsonSchema struct {
+	root map[string]interface{}
+}
+
+// loadJSONSchema 读取并解析 --json-schema 指定的 schema 文件
+func loadJSONSchema(path string) (*jsonSchema, json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read schema file: %v", err)
+	}
+	var root map[string]interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON schema %s: %v", path, err)
+	}
+	return &jsonSchema{root: root}, json.RawMessage(data), nil
+}
+
+// Validate 校验 JSON 文本，返回所有不符合 schema 的位置；schema 为 nil 时只检查是否为合法 JSON
+func (s *jsonSchema) Validate(data string) []string {
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return []string{fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if decoder.More() {
+		return []string{"invalid JSON: unexpected data after top-level value"}
+	}
+	if s == nil {
+		return nil
+	}
+
+	var errs []string
+	s.validate(s.root, value, "$", &errs)
+	return errs
+}
+
+func (s *jsonSchema) validate(schema map[string]interface{}, value interface{}, path string, errs *[]string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := s.resolveRef(ref)
+		if err != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: %v", path, err))
+			return
+		}
+		schema = target
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		*errs = append(*errs, fmt.Sprintf("%s: expected type %v, got %s", path, t, jsonTypeOf(value)))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if jsonEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			*errs = append(*errs, fmt.Sprintf("%s: value is not one of the allowed enum values", path))
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, value) {
+		*errs = append(*errs, fmt.Sprintf("%s: value does not match const", path))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(schema, v, path, errs)
+	case []interface{}:
+		s.validateArray(schema, v, path, errs)
+	case string:
+		validateString(schema, v, path, errs)
+	case json.Number:
+		validateNumber(schema, v, path, errs)
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				s.validate(subSchema, value, path, errs)
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && s.countMatches(anyOf, value) == 0 {
+		*errs = append(*errs, fmt.Sprintf("%s: value does not match any schema in anyOf", path))
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		if n := s.countMatches(oneOf, value); n != 1 {
+			*errs = append(*errs, fmt.Sprintf("%s: value matches %d schemas in oneOf, expected exactly 1", path, n))
+		}
+	}
+}
+
+func (s *jsonSchema) validateObject(schema map[string]interface{}, obj map[string]interface{}, path string, errs *[]string) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, exists := obj[key]; !exists {
+					*errs = append(*errs, fmt.Sprintf("%s: missing required property %q", path, key))
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := path + "." + key
+		if propSchema, ok := properties[key].(map[string]interface{}); ok {
+			s.validate(propSchema, obj[key], childPath, errs)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*errs = append(*errs, fmt.Sprintf("%s: additional property %q is not allowed", path, key))
+			}
+		case map[string]interface{}:
+			s.validate(additional, obj[key], childPath, errs)
+		}
+	}
+}
+
+func (s *jsonSchema) validateArray(schema map[string]interface{}, arr []interface{}, path string, errs *[]string) {
+	if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(arr)) < n {
+		*errs = append(*errs, fmt.Sprintf("%s: expected at least %v items, got %d", path, n, len(arr)))
+	}
+	if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(arr)) > n {
+		*errs = append(*errs, fmt.Sprintf("%s: expected at most %v items, got %d", path, n, len(arr)))
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range arr {
+			s.validate(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+func validateString(schema map[string]interface{}, str string, path string, errs *[]string) {
+	length := float64(len([]rune(str)))
+	if n, ok := schemaNumber(schema, "minLength"); ok && length < n {
+		*errs = append(*errs, fmt.Sprintf("%s: string is shorter than %v characters", path, n))
+	}
+	if n, ok := schemaNumber(schema, "maxLength"); ok && length > n {
+		*errs = append(*errs, fmt.Sprintf("%s: string is longer than %v characters", path, n))
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: invalid pattern %q in schema: %v", path, pattern, err))
+		} else if !re.MatchString(str) {
+			*errs = append(*errs, fmt.Sprintf("%s: string does not match pattern %q", path, pattern))
+		}
+	}
+}
+
+func validateNumber(schema map[string]interface{}, num json.Number, path string, errs *[]string) {
+	f, err := num.Float64()
+	if err != nil {
+		*errs = append(*errs, fmt.Sprintf("%s: invalid number %s", path, num))
+		return
+	}
+	if n, ok := schemaNumber(schema, "minimum"); ok && f < n {
+		*errs = append(*errs, fmt.Sprintf("%s: %v is less than minimum %v", path, num, n))
+	}
+	if n, ok := schemaNumber(schema, "maximum"); ok && f > n {
+		*errs = append(*errs, fmt.Sprintf("%s: %v is greater than maximum %v", path, num, n))
+	}
+	if n, ok := schemaNumber(schema, "exclusiveMinimum"); ok && f <= n {
+		*errs = append(*errs, fmt.Sprintf("%s: %v must be greater than %v", path, num, n))
+	}
+	if n, ok := schemaNumber(schema, "exclusiveMaximum"); ok && f >= n {
+		*errs = append(*errs, fmt.Sprintf("%s: %v must be less than %v", path, num, n))
+	}
+}
+
+// countMatches 返回 value 满足的子 schema 数量
+func (s *jsonSchema) countMatches(schemas []interface{}, value interface{}) int {
+	matches := 0
+	for _, sub := range schemas {
+		subSchema, ok := sub.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var subErrs []string
+		s.validate(subSchema, value, "$", &subErrs)
+		if len(subErrs) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// resolveRef 解析本地 $ref，如 #/$defs/item 或 #/definitions/item
+func (s *jsonSchema) resolveRef(ref string) (map[string]interface{}, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q (only local references are supported)", ref)
+	}
+
+	var current interface{} = s.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		if current, ok = obj[token]; !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	target, ok := current.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("$ref %q does not point to a schema", ref)
+	}
+	return target, nil
+}
+
+// matchesType 检查 value 是否符合 type 关键字（字符串或字符串数组）
+func matchesType(t interface{}, value interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		return matchesSingleType(t, value)
+	case []interface{}:
+		for _, candidate := range t {
+			if name, ok := candidate.(string); ok && matchesSingleType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesSingleType(name string, value interface{}) bool {
+	actual := jsonTypeOf(value)
+	if name == "number" && actual == "integer" {
+		return true
+	}
+	return name == actual
+}
+
+// jsonTypeOf 返回值对应的 JSON Schema 类型名；没有小数部分的数字视为 integer
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// jsonEqual 比较两个 JSON 值，数字按数值比较
+func jsonEqual(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(normalizeJSON(a), normalizeJSON(b))
+}
+
+// normalizeJSON 将 json.Number 转换为 float64，便于与 schema 中的值比较
+func normalizeJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalizeJSON(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = normalizeJSON(item)
+		}
+		return out
+	}
+	return value
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	return toFloat(schema[key])
+}
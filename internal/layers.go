@@ -0,0 +1,430 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 内置默认值，优先级最低
+const (
+	defaultTimeout     = 30
+	defaultMaxTokens   = 4096
+	defaultTemperature = 0.7
+)
+
+// projectConfigName 是提交在代码仓库中的项目配置文件名，从当前目录向上查找
+const projectConfigName = ".sse.yaml"
+
+// configLayer 是一个配置文件层，name 说明层级（install、system、user、repo、project、file）
+type configLayer struct {
+	name string
+	path string
+}
+
+func (l configLayer) String() string {
+	return fmt.Sprintf("%s (%s)", l.name, l.path)
+}
+
+// configSources 记录 config.yaml 顶层字段最终取自哪一层，供 sse config --explain 使用
+var configSources map[string]string
+
+// loadedLayers 是本次加载中实际读取到的配置文件，按优先级从低到高排列
+var loadedLayers []configLayer
+
+// repoTrusted 表示本次加载的 .sse.yaml 所在目录是否在 trusted_projects 中
+var repoTrusted bool
+
+// configLayers 返回按优先级从低到高排列的配置文件层：安装目录 < /etc < 用户配置 < 仓库中的 .sse.yaml < ./config.yaml；
+// 通过 --config 指定文件时只使用该文件
+func configLayers(specifiedFile string) []configLayer {
+	if specifiedFile != "" {
+		return []configLayer{{name: "file", path: specifiedFile}}
+	}
+
+	var layers []configLayer
+
+	// 可执行文件旁随安装包分发的配置，取第一个存在的
+	if execPath, err := os.Executable(); err == nil {
+		execDir := filepath.Dir(execPath)
+		for _, path := range []string{
+			filepath.Join(execDir, "config.yaml"),
+			filepath.Join(execDir, "sse-configs", "config.yaml"),   // 新的配置目录
+			filepath.Join(execDir, "configs", "config.yaml"),       // 兼容旧的配置目录
+			filepath.Join(execDir, "..", "configs", "config.yaml"), // 用于开发环境
+		} {
+			if fileExists(path) {
+				layers = append(layers, configLayer{name: "install", path: path})
+				break
+			}
+		}
+	}
+
+	layers = append(layers,
+		configLayer{name: "system", path: "/etc/sse-client/config.yaml"},
+		configLayer{name: "user", path: userConfigPath()},
+	)
+	if path := findProjectConfig(); path != "" {
+		layers = append(layers, configLayer{name: "repo", path: path})
+	}
+	return append(layers, configLayer{name: "project", path: "./config.yaml"})
+}
+
+// findProjectConfig 从当前目录开始逐级向上查找 .sse.yaml，返回最近的一个，找不到时返回空字符串
+func findProjectConfig() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, projectConfigName)
+		if fileExists(path) {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// userConfigPath 返回用户配置文件路径
+func userConfigPath() string {
+	return os.ExpandEnv("$HOME/.config/sse-client/config.yaml")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readConfigLayers 依次读取配置文件并逐层合并：对象逐个字段合并，其余值（包括列表）由高优先级的层整体覆盖
+func readConfigLayers(layers []configLayer) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+	loadedLayers = nil
+	repoTrusted = false
+	for _, layer := range layers {
+		data, err := os.ReadFile(layer.path)
+		if err != nil {
+			continue
+		}
+		var values map[string]interface{}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %v", layer.path, err)
+		}
+		if layer.name == "repo" {
+			// 信任列表只取自优先级更低的层，项目文件不能信任自己
+			repoTrusted = isTrustedProject(merged["trusted_projects"], filepath.Dir(layer.path))
+			if !repoTrusted {
+				for _, field := range stripProjectSecrets(values, merged) {
+					fmt.Fprintf(os.Stderr, "⚠️  Ignoring %s in untrusted %s (run `sse trust` to allow) | 忽略未信任项目配置中的 %s\n", field, layer.path, field)
+				}
+			}
+			delete(values, "trusted_projects")
+		}
+		for key := range values {
+			configSources[key] = layer.String()
+		}
+		mergeYAML(merged, values)
+		loadedLayers = append(loadedLayers, layer)
+	}
+	return merged, nil
+}
+
+// projectSecretFields 是未信任的项目配置中忽略的 provider 字段：API key（api_key_cmd 会执行命令）、可能携带认证信息的请求头和凭据文件
+var projectSecretFields = []string{"api_key", "api_key_cmd", "api_key_file", "headers", "credentials_file"}
+
+// projectRedirectFields 是未信任的项目配置不能修改的地址：改写已有 provider 的地址会把用户的 key 发送到其他服务
+var projectRedirectFields = []string{"base_url", "token_url"}
+
+// stripProjectSecrets 从未信任的项目配置（包括其中的 profiles）中删除敏感字段，返回被删除的字段路径（trusted_projects 由调用方删除）。
+// 项目中新声明的 provider 可以设置地址（如本地 vLLM），内置 provider 和其他层已声明的 provider 不行
+func stripProjectSecrets(values, lower map[string]interface{}) []string {
+	lowerProviders, _ := lower["providers"].(map[string]interface{})
+	stripped := stripProviderSecrets(values, lowerProviders, "")
+	profiles, _ := values["profiles"].(map[string]interface{})
+	for _, name := range sortedKeys(profiles) {
+		if profile, ok := profiles[name].(map[string]interface{}); ok {
+			stripped = append(stripped, stripProviderSecrets(profile, lowerProviders, "profiles."+name+".")...)
+		}
+	}
+	return stripped
+}
+
+// stripProviderSecrets 删除 values.providers 中各 provider 的敏感字段，prefix 是 values 在配置中的路径
+func stripProviderSecrets(values, lowerProviders map[string]interface{}, prefix string) []string {
+	var stripped []string
+	projectProviders, _ := values["providers"].(map[string]interface{})
+	for _, name := range sortedKeys(projectProviders) {
+		cfg, ok := projectProviders[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fields := projectSecretFields
+		if _, exists := lowerProviders[name]; exists || containsString(builtinProviders, name) {
+			fields = append(append([]string{}, fields...), projectRedirectFields...)
+		}
+		for _, field := range fields {
+			if _, exists := cfg[field]; exists {
+				delete(cfg, field)
+				stripped = append(stripped, fmt.Sprintf("%sproviders.%s.%s", prefix, name, field))
+			}
+		}
+	}
+	return stripped
+}
+
+// isTrustedProject 判断目录是否在 trusted_projects 列表中，列表项可以使用 ~ 表示主目录
+func isTrustedProject(trusted interface{}, dir string) bool {
+	list, _ := trusted.([]interface{})
+	for _, item := range list {
+		path, ok := item.(string)
+		if ok && sameDir(expandHome(path), dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandHome 将路径开头的 ~ 展开为主目录
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mergeYAML 将 src 合并到 dst：两边都是对象时逐层合并，否则用 src 的值覆盖
+func mergeYAML(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeYAML(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// setting 是一个生效的参数值及其来源
+type setting struct {
+	name   string
+	value  string
+	source string
+}
+
+// settingOverride 是某一层对 timeout / max_tokens / temperature 的覆盖，未设置的字段为 nil
+type settingOverride struct {
+	source      string
+	timeout     *int
+	maxTokens   *int
+	temperature *float64
+}
+
+// resolveSettings 按优先级计算 timeout、max_tokens、temperature 的生效值：
+// 内置默认值 < 配置文件各层 < 别名 < 环境变量（SSE_TIMEOUT 等）< 命令行中显式设置的参数
+func resolveSettings(alias *Alias, aliasName string) (timeout, maxTokens int, temperature float64, settings []setting, err error) {
+	timeout, maxTokens, temperature = config.Timeout, config.MaxTokens, config.Temperature
+	timeoutSource := settingSource("timeout")
+	maxTokensSource := settingSource("max_tokens")
+	temperatureSource := settingSource("temperature")
+
+	var overrides []settingOverride
+	if alias != nil {
+		overrides = append(overrides, settingOverride{
+			source:      "alias " + aliasName,
+			maxTokens:   positiveInt(alias.MaxTokens),
+			temperature: alias.Temperature,
+		})
+	}
+
+	env, err := envOverrides()
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	overrides = append(overrides, env...)
+
+	flags := settingOverride{source: "flag"}
+	if appConfig.TimeoutSet {
+		flags.timeout = &appConfig.Timeout
+	}
+	if appConfig.MaxTokensSet {
+		flags.maxTokens = &appConfig.MaxTokens
+	}
+	if appConfig.TemperatureSet {
+		flags.temperature = &appConfig.Temperature
+	}
+	overrides = append(overrides, flags)
+
+	for _, o := range overrides {
+		if o.timeout != nil {
+			timeout, timeoutSource = *o.timeout, o.source
+		}
+		if o.maxTokens != nil {
+			maxTokens, maxTokensSource = *o.maxTokens, o.source
+		}
+		if o.temperature != nil {
+			temperature, temperatureSource = *o.temperature, o.source
+		}
+	}
+
+	settings = []setting{
+		{name: "timeout", value: strconv.Itoa(timeout), source: timeoutSource},
+		{name: "max_tokens", value: strconv.Itoa(maxTokens), source: maxTokensSource},
+		{name: "temperature", value: strconv.FormatFloat(temperature, 'g', -1, 64), source: temperatureSource},
+	}
+	return timeout, maxTokens, temperature, settings, nil
+}
+
+// settingSource 返回配置文件顶层字段的来源，没有任何文件设置时为内置默认值
+func settingSource(key string) string {
+	if source, exists := configSources[key]; exists {
+		return source
+	}
+	return "default"
+}
+
+// envOverrides 读取 SSE_TIMEOUT、SSE_MAX_TOKENS、SSE_TEMPERATURE 环境变量
+func envOverrides() ([]settingOverride, error) {
+	var overrides []settingOverride
+	if value := os.Getenv("SSE_TIMEOUT"); value != "" {
+		timeout, err := strconv.Atoi(value)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid SSE_TIMEOUT '%s' (expected a positive number of seconds)", value)
+		}
+		overrides = append(overrides, settingOverride{source: "env SSE_TIMEOUT", timeout: &timeout})
+	}
+	if value := os.Getenv("SSE_MAX_TOKENS"); value != "" {
+		maxTokens, err := strconv.Atoi(value)
+		if err != nil || maxTokens <= 0 {
+			return nil, fmt.Errorf("invalid SSE_MAX_TOKENS '%s' (expected a positive integer)", value)
+		}
+		overrides = append(overrides, settingOverride{source: "env SSE_MAX_TOKENS", maxTokens: &maxTokens})
+	}
+	if value := os.Getenv("SSE_TEMPERATURE"); value != "" {
+		temperature, err := strconv.ParseFloat(value, 64)
+		if err != nil || temperature < 0 {
+			return nil, fmt.Errorf("invalid SSE_TEMPERATURE '%s' (expected a non-negative number)", value)
+		}
+		overrides = append(overrides, settingOverride{source: "env SSE_TEMPERATURE", temperature: &temperature})
+	}
+	return overrides, nil
+}
+
+func positiveInt(n int) *int {
+	if n <= 0 {
+		return nil
+	}
+	return &n
+}
+
+// saveTarget 返回写入配置时使用的文件：--config 指定的文件，其次是已存在的项目配置，最后是用户配置。
+// 只写入这一个文件，其他层（如 /etc）和环境变量中的值不会被复制进去
+func saveTarget() string {
+	if appConfig.CfgFile != "" {
+		return appConfig.CfgFile
+	}
+	if fileExists("./config.yaml") {
+		return "./config.yaml"
+	}
+	return userConfigPath()
+}
+
+// updateConfigFile 读取目标配置文件，用 update 修改后写回；通过 yaml.Node 修改以保留其余内容和注释
+func updateConfigFile(update func(root *yaml.Node) error) error {
+	return updateConfigFileAt(saveTarget(), update)
+}
+
+// updateConfigFileAt 与 updateConfigFile 相同，但写入指定的文件
+func updateConfigFileAt(path string, update func(root *yaml.Node) error) error {
+
+	var doc yaml.Node
+	if data, err := os.ReadFile(path); err == nil {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("cannot read config file %s: %v", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+
+	if err := update(doc.Content[0]); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(&doc)
+	if err != nil {
+		return fmt.Errorf("cannot marshal config: %v", err)
+	}
+	// 配置文件可能包含 API key，只允许当前用户读写
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("cannot create config directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// mappingValue 返回对象节点中 key 对应的值节点，不存在时按 kind 创建
+func mappingValue(node *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	value := &yaml.Node{Kind: kind}
+	switch kind {
+	case yaml.MappingNode:
+		value.Tag = "!!map"
+	case yaml.SequenceNode:
+		value.Tag = "!!seq"
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
+
+// removeKey 删除对象节点中的 key
+func removeKey(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// setScalar 设置对象节点中 key 的字符串值
+func setScalar(node *yaml.Node, key, value string) {
+	scalar := mappingValue(node, key, yaml.ScalarNode)
+	scalar.Kind = yaml.ScalarNode
+	scalar.Tag = "!!str"
+	scalar.Value = value
+	scalar.Content = nil
+}
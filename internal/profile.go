@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// profileKeys 是 profile 中可以覆盖的顶层字段
+var profileKeys = []string{
+	"providers", "default_provider", "default_model", "routes", "fallback", "aliases",
+	"allowed_providers", "system", "retry", "timeout", "max_tokens", "temperature",
+}
+
+// activeProfile 是本次加载使用的 profile，activeProfileSource 说明它是如何选中的
+var activeProfile, activeProfileSource string
+
+// selectProfile 返回要使用的 profile 及其来源：--profile 优先，其次是 SSE_PROFILE，最后是配置文件中的 profile 字段
+func selectProfile(merged map[string]interface{}) (string, string) {
+	if appConfig.Profile != "" {
+		return appConfig.Profile, "flag"
+	}
+	if name := os.Getenv("SSE_PROFILE"); name != "" {
+		return name, "env SSE_PROFILE"
+	}
+	if name, ok := merged["profile"].(string); ok && name != "" {
+		return name, settingSource("profile")
+	}
+	return "", "default"
+}
+
+// applyProfile 将选中的 profile 逐字段合并到各层配置之上：对象逐个字段合并，其余值整体覆盖。
+// profile 的优先级高于所有配置文件、低于别名、环境变量和命令行参数
+func applyProfile(merged map[string]interface{}) error {
+	activeProfile, activeProfileSource = selectProfile(merged)
+	if activeProfile == "" {
+		return nil
+	}
+
+	profiles, _ := merged["profiles"].(map[string]interface{})
+	profile, exists := profiles[activeProfile]
+	if !exists {
+		return fmt.Errorf("profile '%s' not found (available: %s)", activeProfile, strings.Join(sortedKeys(profiles), ", "))
+	}
+	values, ok := profile.(map[string]interface{})
+	if !ok && profile != nil {
+		return fmt.Errorf("profiles.%s must be a mapping", activeProfile)
+	}
+
+	for key := range values {
+		if !containsString(profileKeys, key) {
+			return fmt.Errorf("profiles.%s: '%s' cannot be set in a profile (allowed: %s)", activeProfile, key, strings.Join(profileKeys, ", "))
+		}
+		configSources[key] = "profile " + activeProfile
+	}
+	mergeYAML(merged, values)
+	return nil
+}
+
+// profileNames 返回按名称排序的所有 profile
+func profileNames() []string {
+	if config == nil {
+		return nil
+	}
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
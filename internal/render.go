@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"sse-client/providers"
+)
+
+const (
+	ansiDim   = "\x1b[2m"
+	ansiReset = "\x1b[0m"
+)
+
+// streamRenderer 将 provider 的流式事件渲染到终端
+//
+// 回答文本写入 stdout；启用 --show-reasoning 时，推理过程写入 stderr（终端中以暗色显示），
+// 这样管道和重定向只会拿到回答本身。
+type streamRenderer struct {
+	out           io.Writer
+	reasoningOut  io.Writer
+	showReasoning bool
+	dim           bool
+	inReasoning   bool
+}
+
+func newStreamRenderer() *streamRenderer {
+	return &streamRenderer{
+		out:           os.Stdout,
+		reasoningOut:  os.Stderr,
+		showReasoning: appConfig.ShowReasoning,
+		dim:           isTerminal(os.Stderr),
+	}
+}
+
+// Handle 处理单个流式事件，可直接作为 providers.EventHandler 使用
+func (r *streamRenderer) Handle(ev providers.StreamEvent) {
+	switch ev.Type {
+	case providers.EventReasoningDelta:
+		if !r.showReasoning {
+			return
+		}
+		if !r.inReasoning {
+			r.inReasoning = true
+			if r.dim {
+				fmt.Fprint(r.reasoningOut, ansiDim)
+			}
+			fmt.Fprintln(r.reasoningOut, "💭 Reasoning | 思考过程:")
+		}
+		fmt.Fprint(r.reasoningOut, ev.Text)
+	case providers.EventTextDelta:
+		r.endReasoning()
+		fmt.Fprint(r.out, ev.Text)
+	}
+}
+
+// Finish 在流结束（包括出错或中断）后收尾输出
+func (r *streamRenderer) Finish() {
+	r.endReasoning()
+	fmt.Fprintln(r.out)
+}
+
+// endReasoning 结束推理过程的输出，恢复终端样式
+func (r *streamRenderer) endReasoning() {
+	if !r.inReasoning {
+		return
+	}
+	r.inReasoning = false
+	if r.dim {
+		fmt.Fprint(r.reasoningOut, ansiReset)
+	}
+	fmt.Fprint(r.reasoningOut, "\n\n")
+}
+
+// isTerminal 判断文件是否连接到终端
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// printUsage 在启用 --usage 时将 token 用量输出到 stderr，避免污染管道中的 stdout
+func printUsage(usage *providers.Usage) {
+	if !appConfig.ShowUsage {
+		return
+	}
+	if usage == nil {
+		fmt.Fprintln(os.Stderr, "📊 Usage | 用量: not reported by provider | 提供商未返回")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "📊 Usage | 用量: input=%d output=%d cached=%d reasoning=%d total=%d\n",
+		usage.Input, usage.Output, usage.Cached, usage.Reasoning, usage.Input+usage.Output)
+}
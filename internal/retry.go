@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryConfig 是 429 / 5xx 等临时错误的重试策略，可在全局和 provider 级别配置
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts,omitempty"` // 包括首次请求在内的最大尝试次数，1 表示不重试
+	BaseDelay   time.Duration `yaml:"base_delay,omitempty"`   // 首次重试前的等待时间，之后每次翻倍
+	MaxDelay    time.Duration `yaml:"max_delay,omitempty"`    // 单次等待时间上限（不限制服务端通过 Retry-After 要求的时间）
+	Jitter      float64       `yaml:"jitter,omitempty"`       // 随机抖动比例（0-1），避免并发脚本同时重试
+}
+
+// defaultRetryConfig 是未配置 retry 时使用的策略
+var defaultRetryConfig = RetryConfig{
+	MaxAttempts: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+	Jitter:      0.2,
+}
+
+// retryable 是可以判断自身是否值得重试的错误，如 providers.APIError 和 providers.StreamError
+type retryable interface {
+	Retryable() bool
+}
+
+// retryPolicy 返回 provider 的重试策略：provider 配置优先，其次是全局配置和默认值
+func retryPolicy(providerName string) RetryConfig {
+	policy := defaultRetryConfig
+	if config == nil {
+		return policy
+	}
+	policy.merge(config.Retry)
+	if cfg, exists := getProviderConfig(providerName); exists {
+		policy.merge(cfg.Retry)
+	}
+	return policy
+}
+
+// merge 用 override 中设置了的字段覆盖当前策略
+func (p *RetryConfig) merge(override *RetryConfig) {
+	if override == nil {
+		return
+	}
+	if override.MaxAttempts > 0 {
+		p.MaxAttempts = override.MaxAttempts
+	}
+	if override.BaseDelay > 0 {
+		p.BaseDelay = override.BaseDelay
+	}
+	if override.MaxDelay > 0 {
+		p.MaxDelay = override.MaxDelay
+	}
+	if override.Jitter > 0 {
+		p.Jitter = override.Jitter
+	}
+}
+
+// shouldRetry 判断错误是否为可重试的临时错误
+func shouldRetry(err error) bool {
+	var r retryable
+	return errors.As(err, &r) && r.Retryable()
+}
+
+// delay 返回第 attempt 次重试（从 1 开始）前的等待时间；服务端建议的等待时间优先
+func (p RetryConfig) delay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// sleepContext 等待 d，ctx 取消时提前返回 ctx.Err()
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
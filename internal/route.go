@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RouteRule 是一条模型路由规则：model（精确匹配）、glob（* 和 ? 通配）、regex 三选一，匹配的模型交给 provider
+type RouteRule struct {
+	Model    string `yaml:"model,omitempty"`
+	Glob     string `yaml:"glob,omitempty"`
+	Regex    string `yaml:"regex,omitempty"`
+	Provider string `yaml:"provider"`
+}
+
+// defaultRoutes 是内置的路由规则，在 config.yaml 的 routes 之后匹配
+var defaultRoutes = []RouteRule{
+	{Glob: "qwen*", Provider: "bailian"},
+	{Glob: "qwq*", Provider: "bailian"},
+	{Regex: `^(gpt|chatgpt-|o[1-9](-|$))`, Provider: "openai"},
+	{Glob: "gemini*", Provider: "google"},
+	{Glob: "claude*", Provider: "anthropic"},
+	{Glob: "deepseek*", Provider: "deepseek"},
+}
+
+func (r RouteRule) String() string {
+	switch {
+	case r.Model != "":
+		return fmt.Sprintf("model %q -> %s", r.Model, r.Provider)
+	case r.Glob != "":
+		return fmt.Sprintf("glob %q -> %s", r.Glob, r.Provider)
+	default:
+		return fmt.Sprintf("regex %q -> %s", r.Regex, r.Provider)
+	}
+}
+
+// pattern 返回规则对应的正则表达式；glob 中 * 匹配任意字符（包括 /），? 匹配单个字符
+func (r RouteRule) pattern() (*regexp.Regexp, error) {
+	switch {
+	case r.Model != "":
+		return regexp.Compile("^" + regexp.QuoteMeta(r.Model) + "$")
+	case r.Glob != "":
+		expr := regexp.QuoteMeta(r.Glob)
+		expr = strings.ReplaceAll(expr, `\*`, ".*")
+		expr = strings.ReplaceAll(expr, `\?`, ".")
+		return regexp.Compile("^" + expr + "$")
+	default:
+		return regexp.Compile(r.Regex)
+	}
+}
+
+// matches 判断模型名称是否匹配该规则，规则无效时不匹配
+func (r RouteRule) matches(model string) bool {
+	re, err := r.pattern()
+	return err == nil && re.MatchString(model)
+}
+
+// validateRoutes 在加载配置时检查路由规则：只能设置一种匹配方式，正则必须合法，provider 必须存在
+func validateRoutes(routes []RouteRule) error {
+	for i, rule := range routes {
+		set := 0
+		for _, value := range []string{rule.Model, rule.Glob, rule.Regex} {
+			if value != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("routes[%d]: exactly one of model, glob or regex must be set", i)
+		}
+		if _, err := rule.pattern(); err != nil {
+			return fmt.Errorf("routes[%d]: invalid regex %q: %v", i, rule.Regex, err)
+		}
+		if !containsString(providerNames(), rule.Provider) {
+			return fmt.Errorf("routes[%d]: unknown provider '%s' (available: %s)", i, rule.Provider, strings.Join(providerNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// routeResult 是模型路由的结果，reason 说明匹配的来源
+type routeResult struct {
+	provider string
+	reason   string
+}
+
+// configuredModelProvider 返回在 models 列表中声明了该模型的 provider，按 providerNames 的顺序匹配以保证结果稳定
+func configuredModelProvider(model string) string {
+	for _, providerName := range configuredProviderNames() {
+		cfg, _ := getProviderConfig(providerName)
+		if containsString(cfg.Models, model) {
+			return providerName
+		}
+	}
+	return ""
+}
+
+// splitProviderModel 拆分 "provider/model" 形式的模型参数（如 openrouter/anthropic/claude-3.5）；
+// 只有第一段是已知 provider 且完整名称不在任何 models 列表中时才拆分
+func splitProviderModel(model string) (string, string, bool) {
+	providerName, rest, ok := strings.Cut(model, "/")
+	if !ok || rest == "" || !containsString(providerNames(), providerName) {
+		return "", model, false
+	}
+	if configuredModelProvider(model) != "" {
+		return "", model, false
+	}
+	return providerName, rest, true
+}
+
+// route 确定模型使用的 provider，依次检查：models 列表、config.yaml 中的 routes、
+// 本地服务（如 Ollama）已安装的模型、内置规则；都不匹配时 provider 为空。
+// provider/model 形式的参数由调用方先用 splitProviderModel 拆分
+func (c *SSEClient) route(model string) routeResult {
+	if providerName := configuredModelProvider(model); providerName != "" {
+		return routeResult{provider: providerName, reason: fmt.Sprintf("listed in %s.models", providerName)}
+	}
+
+	if config != nil {
+		for i, rule := range config.Routes {
+			if rule.matches(model) {
+				return routeResult{provider: rule.Provider, reason: fmt.Sprintf("routes[%d]: %s", i, rule)}
+			}
+		}
+	}
+
+	for _, providerName := range configuredProviderNames() {
+		models, _ := c.discoverModels(context.Background(), providerName)
+		if containsString(models, model) {
+			return routeResult{provider: providerName, reason: fmt.Sprintf("local model discovered by %s", providerName)}
+		}
+	}
+
+	for _, rule := range defaultRoutes {
+		if rule.matches(model) {
+			return routeResult{provider: rule.Provider, reason: fmt.Sprintf("built-in rule: %s", rule)}
+		}
+	}
+
+	return routeResult{}
+}
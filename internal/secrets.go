@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"sse-client/providers"
+)
+
+// apiKeyCmdTimeout 限制 api_key_cmd 的执行时间，如等待 pass / 1Password 解锁
+const apiKeyCmdTimeout = 30 * time.Second
+
+// envRefPattern 匹配 ${ENV_VAR} 形式的环境变量引用；不展开 $VAR，避免误改包含 $ 的 key
+var envRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolvedProviders 记录本次运行中已解析过密钥的 provider，每个 provider 只解析一次
+var resolvedProviders = make(map[string]bool)
+
+// expandEnvRefs 展开 value 中的 ${ENV_VAR}，引用的环境变量未设置时返回错误；field 用于错误信息
+func expandEnvRefs(value, field string) (string, error) {
+	var missing []string
+	expanded := envRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envRefPattern.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%s: environment variable %s is not set", field, strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// resolveProviderSecrets 在 provider 实际被使用前解析它的密钥：api_key_cmd 优先，其次是 api_key_file，最后是 api_key；
+// api_key、api_key_file 和 headers 中的 ${ENV_VAR} 在这里展开。解析结果写回配置并传给 providers 包
+func resolveProviderSecrets(ctx context.Context, providerName string) error {
+	if config == nil || resolvedProviders[providerName] {
+		return nil
+	}
+	cfg, exists := config.Providers[providerName]
+	if !exists {
+		return nil
+	}
+
+	apiKey, err := resolveAPIKey(ctx, providerName, cfg)
+	if err != nil {
+		return err
+	}
+	cfg.APIKey, cfg.APIKeyCmd, cfg.APIKeyFile = apiKey, "", ""
+
+	if len(cfg.Headers) > 0 {
+		headers := make(map[string]string, len(cfg.Headers))
+		for key, value := range cfg.Headers {
+			if headers[key], err = expandEnvRefs(value, fmt.Sprintf("%s.headers.%s", providerName, key)); err != nil {
+				return err
+			}
+		}
+		cfg.Headers = headers
+	}
+
+	config.Providers[providerName] = cfg
+	providers.SetProviderConfig(providerName, toProviderConfig(cfg))
+	resolvedProviders[providerName] = true
+	return nil
+}
+
+// resolveAPIKey 返回 provider 的 API key，不修改配置
+func resolveAPIKey(ctx context.Context, providerName string, cfg ProviderConfig) (string, error) {
+	switch {
+	case cfg.APIKeyCmd != "":
+		return runAPIKeyCmd(ctx, providerName, cfg.APIKeyCmd)
+	case cfg.APIKeyFile != "":
+		path, err := expandEnvRefs(cfg.APIKeyFile, providerName+".api_key_file")
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(expandHome(path))
+		if err != nil {
+			return "", fmt.Errorf("%s.api_key_file: %v", providerName, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	default:
+		return expandEnvRefs(cfg.APIKey, providerName+".api_key")
+	}
+}
+
+// runAPIKeyCmd 执行 api_key_cmd 并返回去掉首尾空白的输出；命令的 stderr 保留在终端，便于输入密码等交互
+func runAPIKeyCmd(ctx context.Context, providerName, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, apiKeyCmdTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "bash", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("%s.api_key_cmd timed out after %s", providerName, apiKeyCmdTimeout)
+		}
+		return "", fmt.Errorf("%s.api_key_cmd failed: %v", providerName, err)
+	}
+
+	apiKey := strings.TrimSpace(stdout.String())
+	if apiKey == "" {
+		return "", fmt.Errorf("%s.api_key_cmd printed nothing", providerName)
+	}
+	return apiKey, nil
+}
+
+// maskSecret 隐藏密钥，只保留开头和结尾几个字符用于辨认
+func maskSecret(secret string) string {
+	if len(secret) <= 12 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:3] + "..." + secret[len(secret)-4:]
+}
+
+// maskProfileSecrets 返回 profile 的副本，其中 providers.*.api_key 和 headers 的值已隐藏
+func maskProfileSecrets(profile map[string]interface{}) map[string]interface{} {
+	masked := make(map[string]interface{}, len(profile))
+	for key, value := range profile {
+		masked[key] = value
+	}
+	profileProviders, _ := profile["providers"].(map[string]interface{})
+	maskedProviders := make(map[string]interface{}, len(profileProviders))
+	for name, value := range profileProviders {
+		cfg, ok := value.(map[string]interface{})
+		if !ok {
+			maskedProviders[name] = value
+			continue
+		}
+		maskedCfg := make(map[string]interface{}, len(cfg))
+		for field, v := range cfg {
+			maskedCfg[field] = v
+		}
+		if apiKey, ok := cfg["api_key"].(string); ok && !envRefPattern.MatchString(apiKey) {
+			maskedCfg["api_key"] = maskSecret(apiKey)
+		}
+		if headers, ok := cfg["headers"].(map[string]interface{}); ok {
+			maskedHeaders := make(map[string]interface{}, len(headers))
+			for header, v := range headers {
+				if s, ok := v.(string); ok && !envRefPattern.MatchString(s) {
+					v = maskSecret(s)
+				}
+				maskedHeaders[header] = v
+			}
+			maskedCfg["headers"] = maskedHeaders
+		}
+		maskedProviders[name] = maskedCfg
+	}
+	if profileProviders != nil {
+		masked["providers"] = maskedProviders
+	}
+	return masked
+}
+
+// describeAPIKey 返回 sse config 中显示的 API key：默认只显示来源和隐藏后的 key，reveal 时解析并显示完整的 key
+func describeAPIKey(providerName string, cfg ProviderConfig, reveal bool) string {
+	if reveal {
+		apiKey, err := resolveAPIKey(context.Background(), providerName, cfg)
+		if err != nil {
+			return fmt.Sprintf("error (%v)", err)
+		}
+		return apiKey
+	}
+
+	switch {
+	case cfg.APIKeyCmd != "":
+		return fmt.Sprintf("<api_key_cmd: %s>", cfg.APIKeyCmd)
+	case cfg.APIKeyFile != "":
+		return fmt.Sprintf("<api_key_file: %s>", cfg.APIKeyFile)
+	case envRefPattern.MatchString(cfg.APIKey):
+		apiKey, err := expandEnvRefs(cfg.APIKey, providerName+".api_key")
+		if err != nil {
+			return fmt.Sprintf("%s (%v)", cfg.APIKey, err)
+		}
+		return fmt.Sprintf("%s (%s)", maskSecret(apiKey), cfg.APIKey)
+	default:
+		return maskSecret(cfg.APIKey)
+	}
+}
@@ -1,206 +1,314 @@
 package providers
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
-	"strings"
-	"time"
+
+	"sse-client/providers/sse"
 )
 
-type AnthropicProvider struct{}
+// AnthropicProvider 调用 Anthropic 格式的 API，name 为配置中的 provider 名称
+type AnthropicProvider struct {
+	name string
+}
 
 type AnthropicRequest struct {
-	Model     string             `json:"model"`
-	MaxTokens int                `json:"max_tokens"`
-	Messages  []AnthropicMessage `json:"messages"`
-	Stream    bool               `json:"stream"`
+	Model       string               `json:"model"`
+	MaxTokens   int                  `json:"max_tokens"`
+	System      string               `json:"system,omitempty"`
+	Messages    []AnthropicMessage   `json:"messages"`
+	Temperature *float64             `json:"temperature,omitempty"`
+	Thinking    *AnthropicThinking   `json:"thinking,omitempty"`
+	Tools       []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice  *AnthropicToolChoice `json:"tool_choice,omitempty"`
+	Stream      bool                 `json:"stream"`
+}
+
+// AnthropicToolChoice 指定模型必须调用的工具
+type AnthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// anthropicJSONTool 是结构化输出时强制调用的工具名；Anthropic 没有 response_format，
+// 通过强制调用以 schema 为 input_schema 的工具获得 JSON，其参数作为回答文本返回
+const anthropicJSONTool = "structured_output"
+
+type AnthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+// AnthropicThinking 开启扩展思考，budget_tokens 必须小于 max_tokens
+type AnthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
 }
 
 type AnthropicMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
 }
 
+type AnthropicTextContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type AnthropicImageContent struct {
+	Type   string               `json:"type"`
+	Source AnthropicImageSource `json:"source"`
+}
+
+type AnthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// anthropicImageTypes 是 Anthropic 接受的图片 MIME 类型
+var anthropicImageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
 type AnthropicResponse struct {
-	Type  string `json:"type"`
-	Delta struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentBlock struct {
 		Type string `json:"type"`
-		Text string `json:"text"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
 	} `json:"delta"`
+	Message struct {
+		Usage AnthropicUsage `json:"usage"`
+	} `json:"message"`
+	Usage AnthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// AnthropicUsage 是 message_start / message_delta 事件中的 token 用量
+type AnthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
 }
 
-func NewAnthropicProvider() *AnthropicProvider {
-	return &AnthropicProvider{}
+func NewAnthropicProvider(name string) *AnthropicProvider {
+	return &AnthropicProvider{name: name}
 }
 
 func (p *AnthropicProvider) SupportsModel(model string) bool {
-	models := GetModelsForProvider("anthropic")
+	models := GetModelsForProvider(p.name)
 	return ModelInList(model, models)
 }
 
-func (p *AnthropicProvider) Stream(model, message string, temperature float64, maxTokens, timeout int) error {
-	cfg, exists := GetProviderConfig("anthropic")
-	if !exists {
-		return GetProviderNotConfiguredError("anthropic")
-	}
-
-	baseURL := cfg.BaseURL
-	apiKey := cfg.APIKey
+// newAnthropicRequest 将通用请求转换为 Anthropic 格式，system 消息放入顶层 system 字段
+func newAnthropicRequest(req *ChatRequest) (AnthropicRequest, error) {
+	var messages []AnthropicMessage
+	for _, msg := range req.Messages {
+		if msg.Role == RoleSystem {
+			continue
+		}
 
-	if apiKey == "" {
-		return GetAPIKeyConfigError("anthropic")
-	}
+		// 纯文本消息直接使用字符串内容
+		if !msg.HasImages() {
+			messages = append(messages, AnthropicMessage{Role: string(msg.Role), Content: msg.Text()})
+			continue
+		}
 
-	req := AnthropicRequest{
-		Model:     model,
-		MaxTokens: maxTokens,
-		Messages: []AnthropicMessage{
-			{Role: "user", Content: message},
-		},
-		Stream: true,
-	}
+		// 构建多模态消息，图片以 base64 image 内容块发送
+		var content []interface{}
+		for _, part := range msg.Parts {
+			if part.Type != PartImage {
+				if text := part.AsText(); text != "" {
+					content = append(content, AnthropicTextContent{Type: "text", Text: text})
+				}
+				continue
+			}
 
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return err
+			encodedImage, mimeType, err := EncodeImageToBase64(part.ImagePath)
+			if err != nil {
+				return AnthropicRequest{}, fmt.Errorf("failed to encode image: %v", err)
+			}
+			if err := ValidateImageMIMEType("anthropic", part.ImagePath, mimeType, anthropicImageTypes); err != nil {
+				return AnthropicRequest{}, err
+			}
+			content = append(content, AnthropicImageContent{
+				Type: "image",
+				Source: AnthropicImageSource{
+					Type:      "base64",
+					MediaType: mimeType,
+					Data:      encodedImage,
+				},
+			})
+		}
+		messages = append(messages, AnthropicMessage{Role: string(msg.Role), Content: content})
 	}
 
-	httpReq, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
+	anthropicReq := AnthropicRequest{
+		Model:     req.Model,
+		MaxTokens: req.MaxTokens,
+		System:    req.SystemPrompt(),
+		Messages:  messages,
+		Stream:    true,
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", apiKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
-	httpReq.Header.Set("Accept", "text/event-stream")
-
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return err
+	for _, tool := range req.Tools {
+		anthropicReq.Tools = append(anthropicReq.Tools, AnthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.toolParameters(),
+		})
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error: %s", string(body))
+	if format := req.ResponseFormat; format != nil {
+		// 强制调用工具时不能开启扩展思考
+		if req.Reasoning.Enabled() {
+			return AnthropicRequest{}, fmt.Errorf("anthropic does not support structured output together with --reasoning")
+		}
+		schema := format.Schema
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object"}`)
+		}
+		anthropicReq.Tools = append(anthropicReq.Tools, AnthropicTool{
+			Name:        anthropicJSONTool,
+			Description: "Respond with the final answer as structured JSON.",
+			InputSchema: schema,
+		})
+		anthropicReq.ToolChoice = &AnthropicToolChoice{Type: "tool", Name: anthropicJSONTool}
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var response AnthropicResponse
-			if err := json.Unmarshal([]byte(data), &response); err == nil {
-				if response.Type == "content_block_delta" && response.Delta.Type == "text_delta" {
-					fmt.Print(response.Delta.Text)
-				}
-			}
+	if req.Reasoning.Enabled() {
+		// 开启思考时 max_tokens 必须大于 budget_tokens，且不能设置 temperature
+		budget := req.Reasoning.Budget()
+		anthropicReq.Thinking = &AnthropicThinking{Type: "enabled", BudgetTokens: budget}
+		if anthropicReq.MaxTokens <= budget {
+			anthropicReq.MaxTokens = budget + req.MaxTokens
 		}
+	} else {
+		temperature := req.Temperature
+		anthropicReq.Temperature = &temperature
 	}
 
-	fmt.Println()
-	return scanner.Err()
-}
-func (p *AnthropicProvider) StreamWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
-	// Anthropic 的图片支持暂未实现
-	if imagePath != "" {
-		return fmt.Errorf("image support for Anthropic models not implemented yet")
-	}
-	return p.Stream(model, message, temperature, maxTokens, timeout)
+	return anthropicReq, nil
 }
 
-// GetFullResponse 获取完整的AI响应（非流式）
-func (p *AnthropicProvider) GetFullResponse(model, message string, temperature float64, maxTokens, timeout int) (string, error) {
-	cfg, exists := GetProviderConfig("anthropic")
+// Stream 发起流式请求，并将解析出的事件交给 handler
+func (p *AnthropicProvider) Stream(ctx context.Context, req *ChatRequest, handler EventHandler) error {
+	cfg, exists := GetProviderConfig(p.name)
 	if !exists {
-		return "", GetProviderNotConfiguredError("anthropic")
+		return GetProviderNotConfiguredError(p.name)
 	}
 
 	baseURL := cfg.BaseURL
 	apiKey := cfg.APIKey
 
 	if apiKey == "" {
-		return "", GetAPIKeyConfigError("anthropic")
+		return GetAPIKeyConfigError(p.name)
 	}
 
-	req := AnthropicRequest{
-		Model: model,
-		Messages: []AnthropicMessage{
-			{Role: "user", Content: message},
-		},
-		MaxTokens: maxTokens,
-		Stream:    true,
-	}
-
-	jsonData, err := json.Marshal(req)
+	anthropicReq, err := newAnthropicRequest(req)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	httpReq, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(jsonData))
+	resp, err := postStream(ctx, baseURL, anthropicReq, authHeaders(cfg, "x-api-key", "", map[string]string{
+		"anthropic-version": "2023-06-01",
+	}), req)
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer resp.Body.Close()
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", apiKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
-	httpReq.Header.Set("Accept", "text/event-stream")
+	return contextError(ctx, decodeAnthropicStream(p.name, resp.Body, handler, req.ResponseFormat != nil))
+}
 
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+// decodeAnthropicStream 解析 Anthropic 的 SSE 响应，并将事件交给 handler；
+// structured 为 true 时，anthropicJSONTool 的参数作为回答文本返回
+func decodeAnthropicStream(providerName string, body io.Reader, handler EventHandler, structured bool) error {
+	// 输入用量只在 message_start 中给出，输出用量在 message_delta 中累计更新
+	var usage Usage
+	jsonBlock := -1
+	reader := sse.NewReader(body)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.Type == "ping" {
+			continue
+		}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API error: %s", string(body))
-	}
+		var response AnthropicResponse
+		if err := json.Unmarshal([]byte(event.Data), &response); err != nil {
+			return decodeChunkError(providerName, event.Data, err)
+		}
 
-	var fullResponse strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
+		switch response.Type {
+		case "error":
+			err := &StreamError{Provider: providerName, Type: response.Error.Type, Message: response.Error.Message}
+			handler.emit(StreamEvent{Type: EventError, Err: err})
+			return err
+		case "message_start":
+			u := response.Message.Usage
+			usage.Input = u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+			usage.Cached = u.CacheReadInputTokens
+			usage.Output = u.OutputTokens
+			current := usage
+			handler.emit(StreamEvent{Type: EventUsage, Usage: &current})
+		case "content_block_start":
+			// tool_use 内容块的 id 和名称在块开始时给出，参数随后以 input_json_delta 分段返回
+			if block := response.ContentBlock; block.Type == "tool_use" {
+				if structured && block.Name == anthropicJSONTool {
+					jsonBlock = response.Index
+					continue
+				}
+				handler.emit(StreamEvent{Type: EventToolCallDelta, ToolCall: &ToolCallDelta{
+					Index: response.Index,
+					ID:    block.ID,
+					Name:  block.Name,
+				}})
 			}
-
-			var response AnthropicResponse
-			if err := json.Unmarshal([]byte(data), &response); err == nil {
-				if response.Delta.Text != "" {
-					fullResponse.WriteString(response.Delta.Text)
+		case "content_block_delta":
+			switch response.Delta.Type {
+			case "text_delta":
+				handler.emit(StreamEvent{Type: EventTextDelta, Text: response.Delta.Text})
+			case "thinking_delta":
+				handler.emit(StreamEvent{Type: EventReasoningDelta, Text: response.Delta.Thinking})
+			case "input_json_delta":
+				if response.Index == jsonBlock {
+					handler.emit(StreamEvent{Type: EventTextDelta, Text: response.Delta.PartialJSON})
+					continue
 				}
+				handler.emit(StreamEvent{Type: EventToolCallDelta, ToolCall: &ToolCallDelta{
+					Index:     response.Index,
+					Arguments: response.Delta.PartialJSON,
+				}})
+			}
+		case "message_delta":
+			usage.Output = response.Usage.OutputTokens
+			current := usage
+			handler.emit(StreamEvent{Type: EventUsage, Usage: &current})
+			if response.Delta.StopReason != "" {
+				handler.emit(StreamEvent{Type: EventFinish, FinishReason: response.Delta.StopReason})
 			}
+		case "message_stop":
+			return nil
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		return "", err
-	}
-
-	return fullResponse.String(), nil
-}
-
-// GetFullResponseWithImage 获取完整的AI响应（非流式，支持图片）
-func (p *AnthropicProvider) GetFullResponseWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) (string, error) {
-	// Anthropic 的图片支持暂未实现
-	if imagePath != "" {
-		return "", fmt.Errorf("image support for Anthropic models not implemented yet")
-	}
-	return p.GetFullResponse(model, message, temperature, maxTokens, timeout)
 }
@@ -1,24 +1,32 @@
 package providers
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
-	"strings"
-	"time"
+
+	"sse-client/providers/sse"
 )
 
-type BailianProvider struct{}
+// BailianProvider 调用 Bailian 格式的 API，name 为配置中的 provider 名称
+type BailianProvider struct {
+	name string
+}
 
 type BailianRequest struct {
-	Model       string           `json:"model"`
-	Messages    []BailianMessage `json:"messages"`
-	MaxTokens   int              `json:"max_tokens"`
-	Temperature float64          `json:"temperature"`
-	Stream      bool             `json:"stream"`
+	Model          string                `json:"model"`
+	Messages       []BailianMessage      `json:"messages"`
+	MaxTokens      int                   `json:"max_tokens"`
+	Temperature    float64               `json:"temperature"`
+	Stream         bool                  `json:"stream"`
+	StreamOptions  *StreamOptions        `json:"stream_options,omitempty"`
+	Tools          []OpenAITool          `json:"tools,omitempty"`
+	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"`
+
+	// Qwen3 等混合思考模型的推理开关与思考预算
+	EnableThinking *bool `json:"enable_thinking,omitempty"`
+	ThinkingBudget int   `json:"thinking_budget,omitempty"`
 }
 
 type BailianMessage struct {
@@ -41,373 +49,146 @@ type BailianImageContent struct {
 type BailianResponse struct {
 	Choices []struct {
 		Delta struct {
-			Content string `json:"content"`
+			Content          string                `json:"content"`
+			ReasoningContent string                `json:"reasoning_content"` // 推理模型（deepseek-r1、QwQ、Qwen3 等）的思考过程
+			ToolCalls        []OpenAIToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
 		FinishReason *string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *OpenAIUsage        `json:"usage"`
+	Error *StreamErrorPayload `json:"error"`
 }
 
-func NewBailianProvider() *BailianProvider {
-	return &BailianProvider{}
+func NewBailianProvider(name string) *BailianProvider {
+	return &BailianProvider{name: name}
 }
 
 func (p *BailianProvider) SupportsModel(model string) bool {
-	models := GetModelsForProvider("bailian")
+	models := GetModelsForProvider(p.name)
 	return ModelInList(model, models)
 }
 
-func (p *BailianProvider) Stream(model, message string, temperature float64, maxTokens, timeout int) error {
-	cfg, exists := GetProviderConfig("bailian")
-	if !exists {
-		return GetProviderNotConfiguredError("bailian")
-	}
-
-	baseURL := cfg.BaseURL
-	apiKey := cfg.APIKey
-
-	if apiKey == "" {
-		return GetAPIKeyConfigError("bailian")
-	}
-
-	req := BailianRequest{
-		Model: model,
-		Messages: []BailianMessage{
-			{Role: "user", Content: message},
-		},
-		MaxTokens:   maxTokens,
-		Temperature: temperature,
-		Stream:      true,
-	}
-
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
-
-	httpReq, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	httpReq.Header.Set("Accept", "text/event-stream")
-
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error: %s", string(body))
-	}
-
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var response BailianResponse
-			if err := json.Unmarshal([]byte(data), &response); err == nil {
-				if len(response.Choices) > 0 {
-					fmt.Print(response.Choices[0].Delta.Content)
-					if response.Choices[0].FinishReason != nil {
-						break
-					}
-				}
-			}
-		}
-	}
-
-	fmt.Println()
-	return scanner.Err()
-}
-
-func (p *BailianProvider) StreamWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
-	cfg, exists := GetProviderConfig("bailian")
-	if !exists {
-		return GetProviderNotConfiguredError("bailian")
-	}
-
-	baseURL := cfg.BaseURL
-	apiKey := cfg.APIKey
-
-	if apiKey == "" {
-		return GetAPIKeyConfigError("bailian")
-	}
-
-	// 构建消息内容
+// newBailianRequest 将通用请求转换为百炼（OpenAI 兼容）格式
+func newBailianRequest(req *ChatRequest) (BailianRequest, error) {
 	var messages []BailianMessage
-
-	if imagePath != "" {
-		// 编码图片
-		encodedImage, mimeType, err := EncodeImageToBase64(imagePath)
-		if err != nil {
-			return fmt.Errorf("failed to encode image: %v", err)
+	for _, msg := range req.Messages {
+		// 纯文本消息直接使用字符串内容
+		if !msg.HasImages() {
+			messages = append(messages, BailianMessage{Role: string(msg.Role), Content: msg.Text()})
+			continue
 		}
 
 		// 构建多模态消息
-		content := []interface{}{
-			BailianTextContent{
-				Type: "text",
-				Text: message,
-			},
-			BailianImageContent{
+		var content []interface{}
+		for _, part := range msg.Parts {
+			if part.Type != PartImage {
+				if text := part.AsText(); text != "" {
+					content = append(content, BailianTextContent{Type: "text", Text: text})
+				}
+				continue
+			}
+
+			imageURL, err := ConvertImageToBase64URL(part.ImagePath)
+			if err != nil {
+				return BailianRequest{}, fmt.Errorf("failed to process image: %v", err)
+			}
+			content = append(content, BailianImageContent{
 				Type: "image_url",
 				ImageURL: struct {
 					URL string `json:"url"`
-				}{
-					URL: fmt.Sprintf("data:%s;base64,%s", mimeType, encodedImage),
-				},
-			},
-		}
-		messages = []BailianMessage{
-			{Role: "user", Content: content},
+				}{URL: imageURL},
+			})
 		}
-	} else {
-		// 纯文本消息
-		messages = []BailianMessage{
-			{Role: "user", Content: message},
-		}
-	}
-
-	req := BailianRequest{
-		Model:       model,
-		Messages:    messages,
-		MaxTokens:   maxTokens,
-		Temperature: temperature,
-		Stream:      true,
-	}
-
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
-
-	httpReq, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	httpReq.Header.Set("Accept", "text/event-stream")
-
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return err
+		messages = append(messages, BailianMessage{Role: string(msg.Role), Content: content})
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error: %s", string(body))
+	bailianReq := BailianRequest{
+		Model:          req.Model,
+		Messages:       messages,
+		MaxTokens:      req.MaxTokens,
+		Temperature:    req.Temperature,
+		Stream:         true,
+		StreamOptions:  &StreamOptions{IncludeUsage: true},
+		Tools:          newOpenAITools(req.Tools),
+		ResponseFormat: newOpenAIResponseFormat(req.ResponseFormat),
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var response BailianResponse
-			if err := json.Unmarshal([]byte(data), &response); err == nil {
-				if len(response.Choices) > 0 {
-					fmt.Print(response.Choices[0].Delta.Content)
-					if response.Choices[0].FinishReason != nil {
-						break
-					}
-				}
-			}
-		}
+	if req.Reasoning != ReasoningDefault {
+		enabled := req.Reasoning.Enabled()
+		bailianReq.EnableThinking = &enabled
+		bailianReq.ThinkingBudget = req.Reasoning.Budget()
 	}
 
-	fmt.Println()
-	return scanner.Err()
+	return bailianReq, nil
 }
 
-// GetFullResponse 获取完整的AI响应（非流式）
-func (p *BailianProvider) GetFullResponse(model, message string, temperature float64, maxTokens, timeout int) (string, error) {
-	cfg, exists := GetProviderConfig("bailian")
+// Stream 发起流式请求，并将解析出的事件交给 handler
+func (p *BailianProvider) Stream(ctx context.Context, req *ChatRequest, handler EventHandler) error {
+	cfg, exists := GetProviderConfig(p.name)
 	if !exists {
-		return "", GetProviderNotConfiguredError("bailian")
+		return GetProviderNotConfiguredError(p.name)
 	}
 
 	baseURL := cfg.BaseURL
 	apiKey := cfg.APIKey
 
 	if apiKey == "" {
-		return "", GetAPIKeyConfigError("bailian")
-	}
-
-	req := BailianRequest{
-		Model: model,
-		Messages: []BailianMessage{
-			{Role: "user", Content: message},
-		},
-		MaxTokens:   maxTokens,
-		Temperature: temperature,
-		Stream:      true, // 仍然使用流式，但收集所有内容
-	}
-
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return "", err
+		return GetAPIKeyConfigError(p.name)
 	}
 
-	httpReq, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(jsonData))
+	bailianReq, err := newBailianRequest(req)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	httpReq.Header.Set("Accept", "text/event-stream")
-
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	resp, err := client.Do(httpReq)
+	resp, err := postStream(ctx, baseURL, bailianReq, authHeaders(cfg, "Authorization", "Bearer", nil), req)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API error: %s", string(body))
-	}
-
-	var fullResponse strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var response BailianResponse
-			if err := json.Unmarshal([]byte(data), &response); err == nil {
-				if len(response.Choices) > 0 {
-					fullResponse.WriteString(response.Choices[0].Delta.Content)
-					if response.Choices[0].FinishReason != nil {
-						break
-					}
-				}
-			}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return "", err
-	}
-
-	return fullResponse.String(), nil
+	return contextError(ctx, decodeBailianStream(p.name, resp.Body, handler))
 }
 
-// GetFullResponseWithImage 获取完整的AI响应（非流式，支持图片）
-func (p *BailianProvider) GetFullResponseWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) (string, error) {
-	cfg, exists := GetProviderConfig("bailian")
-	if !exists {
-		return "", GetProviderNotConfiguredError("bailian")
-	}
-
-	baseURL := cfg.BaseURL
-	apiKey := cfg.APIKey
-
-	if apiKey == "" {
-		return "", GetAPIKeyConfigError("bailian")
-	}
-
-	// 构建包含图片的消息内容
-	var content []interface{}
-	content = append(content, BailianTextContent{Type: "text", Text: message})
-
-	if imagePath != "" {
-		imageURL, err := ConvertImageToBase64URL(imagePath)
+// decodeBailianStream 解析 bailian 的 SSE 响应，并将事件交给 handler
+func decodeBailianStream(providerName string, body io.Reader, handler EventHandler) error {
+	reader := sse.NewReader(body)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			return "", fmt.Errorf("failed to process image: %v", err)
+			return err
+		}
+		if event.Data == "[DONE]" {
+			return nil
 		}
-		content = append(content, BailianImageContent{
-			Type: "image_url",
-			ImageURL: struct {
-				URL string `json:"url"`
-			}{URL: imageURL},
-		})
-	}
-
-	req := BailianRequest{
-		Model: model,
-		Messages: []BailianMessage{
-			{Role: "user", Content: content},
-		},
-		MaxTokens:   maxTokens,
-		Temperature: temperature,
-		Stream:      true,
-	}
-
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return "", err
-	}
-
-	httpReq, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	httpReq.Header.Set("Accept", "text/event-stream")
-
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API error: %s", string(body))
-	}
 
-	var fullResponse strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
+		var response BailianResponse
+		if err := json.Unmarshal([]byte(event.Data), &response); err != nil {
+			return decodeChunkError(providerName, event.Data, err)
+		}
+		if response.Error != nil {
+			err := &StreamError{Provider: providerName, Type: response.Error.Type, Message: response.Error.Message}
+			handler.emit(StreamEvent{Type: EventError, Err: err})
+			return err
+		}
+		if response.Usage != nil {
+			handler.emit(StreamEvent{Type: EventUsage, Usage: response.Usage.toUsage()})
+		}
+		if len(response.Choices) > 0 {
+			choice := response.Choices[0]
+			if choice.Delta.ReasoningContent != "" {
+				handler.emit(StreamEvent{Type: EventReasoningDelta, Text: choice.Delta.ReasoningContent})
 			}
-
-			var response BailianResponse
-			if err := json.Unmarshal([]byte(data), &response); err == nil {
-				if len(response.Choices) > 0 {
-					fullResponse.WriteString(response.Choices[0].Delta.Content)
-					if response.Choices[0].FinishReason != nil {
-						break
-					}
-				}
+			if choice.Delta.Content != "" {
+				handler.emit(StreamEvent{Type: EventTextDelta, Text: choice.Delta.Content})
+			}
+			emitOpenAIToolCalls(choice.Delta.ToolCalls, handler)
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				handler.emit(StreamEvent{Type: EventFinish, FinishReason: *choice.FinishReason})
 			}
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		return "", err
-	}
-
-	return fullResponse.String(), nil
 }
@@ -1,24 +1,28 @@
 package providers
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
-	"strings"
-	"time"
+
+	"sse-client/providers/sse"
 )
 
-type DeepSeekProvider struct{}
+// DeepSeekProvider 调用 DeepSeek 格式的 API，name 为配置中的 provider 名称
+type DeepSeekProvider struct {
+	name string
+}
 
 type DeepSeekRequest struct {
-	Model       string            `json:"model"`
-	Messages    []DeepSeekMessage `json:"messages"`
-	Temperature float64           `json:"temperature"`
-	MaxTokens   int               `json:"max_tokens"`
-	Stream      bool              `json:"stream"`
+	Model          string                `json:"model"`
+	Messages       []DeepSeekMessage     `json:"messages"`
+	Temperature    float64               `json:"temperature"`
+	MaxTokens      int                   `json:"max_tokens"`
+	Stream         bool                  `json:"stream"`
+	StreamOptions  *StreamOptions        `json:"stream_options,omitempty"`
+	Tools          []OpenAITool          `json:"tools,omitempty"`
+	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"`
 }
 
 type DeepSeekMessage struct {
@@ -29,21 +33,22 @@ type DeepSeekMessage struct {
 type DeepSeekResponse struct {
 	Choices []struct {
 		Delta struct {
-			Content string `json:"content"`
+			Content          string                `json:"content"`
+			ReasoningContent string                `json:"reasoning_content"` // 推理模型（deepseek-r1、QwQ、Qwen3 等）的思考过程
+			ToolCalls        []OpenAIToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-		FinishReason string `json:"finish_reason"`
+		FinishReason *string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *OpenAIUsage        `json:"usage"`
+	Error *StreamErrorPayload `json:"error"`
 }
 
-func NewDeepSeekProvider() *DeepSeekProvider {
-	return &DeepSeekProvider{}
+func NewDeepSeekProvider(name string) *DeepSeekProvider {
+	return &DeepSeekProvider{name: name}
 }
 
 func (p *DeepSeekProvider) SupportsModel(model string) bool {
-	models := GetModelsForProvider("deepseek")
+	models := GetModelsForProvider(p.name)
 	for _, m := range models {
 		if m == model {
 			return true
@@ -52,150 +57,98 @@ func (p *DeepSeekProvider) SupportsModel(model string) bool {
 	return false
 }
 
-func (p *DeepSeekProvider) Stream(model, message string, temperature float64, maxTokens, timeout int) error {
-	return p.StreamWithImage(model, message, "", temperature, maxTokens, timeout)
-}
-
-func (p *DeepSeekProvider) StreamWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
-	config := GetConfig()
-	providerConfig, exists := config.Providers["deepseek"]
-	if !exists {
-		return fmt.Errorf("deepseek provider not configured")
+// newDeepSeekRequest 将通用请求转换为 DeepSeek（OpenAI 兼容）格式
+func newDeepSeekRequest(req *ChatRequest) (DeepSeekRequest, error) {
+	if req.HasImages() {
+		return DeepSeekRequest{}, fmt.Errorf("deepseek provider does not support image input yet")
 	}
 
-	if imagePath != "" {
-		return fmt.Errorf("deepseek provider does not support image input yet")
+	var messages []DeepSeekMessage
+	for _, msg := range req.Messages {
+		messages = append(messages, DeepSeekMessage{
+			Role:    string(msg.Role),
+			Content: msg.Text(),
+		})
 	}
 
-	reqBody := DeepSeekRequest{
-		Model: model,
-		Messages: []DeepSeekMessage{
-			{
-				Role:    "user",
-				Content: message,
-			},
-		},
-		Temperature: temperature,
-		MaxTokens:   maxTokens,
-		Stream:      true,
+	deepseekReq := DeepSeekRequest{
+		Model:         req.Model,
+		Messages:      messages,
+		Temperature:   req.Temperature,
+		MaxTokens:     req.MaxTokens,
+		Stream:        true,
+		StreamOptions: &StreamOptions{IncludeUsage: true},
+		Tools:         newOpenAITools(req.Tools),
 	}
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return fmt.Errorf("error marshaling request: %v", err)
-	}
-
-	httpReq, err := http.NewRequest("POST", providerConfig.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+providerConfig.APIKey)
-	httpReq.Header.Set("Accept", "text/event-stream")
-
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return fmt.Errorf("error making request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var response DeepSeekResponse
-			if err := json.Unmarshal([]byte(data), &response); err != nil {
-				continue
-			}
-
-			if len(response.Choices) > 0 {
-				content := response.Choices[0].Delta.Content
-				if content != "" {
-					fmt.Print(content)
-				}
-			}
-		}
+	// DeepSeek 只支持 json_object，schema 约束依靠提示词和本地校验
+	if req.ResponseFormat != nil {
+		deepseekReq.ResponseFormat = &OpenAIResponseFormat{Type: "json_object"}
 	}
 
-	fmt.Println() // 添加换行
-	return scanner.Err()
+	return deepseekReq, nil
 }
 
-func (p *DeepSeekProvider) GetFullResponse(model, message string, temperature float64, maxTokens, timeout int) (string, error) {
-	return p.GetFullResponseWithImage(model, message, "", temperature, maxTokens, timeout)
-}
-
-func (p *DeepSeekProvider) GetFullResponseWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) (string, error) {
-	config := GetConfig()
-	providerConfig, exists := config.Providers["deepseek"]
+// Stream 发起流式请求，并将解析出的事件交给 handler
+func (p *DeepSeekProvider) Stream(ctx context.Context, req *ChatRequest, handler EventHandler) error {
+	providerConfig, exists := GetProviderConfig(p.name)
 	if !exists {
-		return "", fmt.Errorf("deepseek provider not configured")
-	}
-
-	if imagePath != "" {
-		return "", fmt.Errorf("deepseek provider does not support image input yet")
+		return GetProviderNotConfiguredError(p.name)
 	}
 
-	reqBody := DeepSeekRequest{
-		Model: model,
-		Messages: []DeepSeekMessage{
-			{
-				Role:    "user",
-				Content: message,
-			},
-		},
-		Temperature: temperature,
-		MaxTokens:   maxTokens,
-		Stream:      false,
-	}
-
-	jsonData, err := json.Marshal(reqBody)
+	reqBody, err := newDeepSeekRequest(req)
 	if err != nil {
-		return "", fmt.Errorf("error marshaling request: %v", err)
+		return err
 	}
 
-	httpReq, err := http.NewRequest("POST", providerConfig.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	resp, err := postStream(ctx, providerConfig.BaseURL+"/chat/completions", reqBody, authHeaders(providerConfig, "Authorization", "Bearer", nil), req)
 	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+providerConfig.APIKey)
-
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return "", fmt.Errorf("error making request: %v", err)
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
+	return contextError(ctx, decodeDeepSeekStream(p.name, resp.Body, handler))
+}
 
-	var response DeepSeekResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("error decoding response: %v", err)
-	}
+// decodeDeepSeekStream 解析 deepseek 的 SSE 响应，并将事件交给 handler
+func decodeDeepSeekStream(providerName string, body io.Reader, handler EventHandler) error {
+	reader := sse.NewReader(body)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.Data == "[DONE]" {
+			return nil
+		}
 
-	if len(response.Choices) > 0 {
-		return response.Choices[0].Message.Content, nil
+		var response DeepSeekResponse
+		if err := json.Unmarshal([]byte(event.Data), &response); err != nil {
+			return decodeChunkError(providerName, event.Data, err)
+		}
+		if response.Error != nil {
+			err := &StreamError{Provider: providerName, Type: response.Error.Type, Message: response.Error.Message}
+			handler.emit(StreamEvent{Type: EventError, Err: err})
+			return err
+		}
+		if response.Usage != nil {
+			handler.emit(StreamEvent{Type: EventUsage, Usage: response.Usage.toUsage()})
+		}
+		if len(response.Choices) > 0 {
+			choice := response.Choices[0]
+			if choice.Delta.ReasoningContent != "" {
+				handler.emit(StreamEvent{Type: EventReasoningDelta, Text: choice.Delta.ReasoningContent})
+			}
+			if choice.Delta.Content != "" {
+				handler.emit(StreamEvent{Type: EventTextDelta, Text: choice.Delta.Content})
+			}
+			emitOpenAIToolCalls(choice.Delta.ToolCalls, handler)
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				handler.emit(StreamEvent{Type: EventFinish, FinishReason: *choice.FinishReason})
+			}
+		}
 	}
-
-	return "", fmt.Errorf("no response content")
 }
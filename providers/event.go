@@ -0,0 +1,148 @@
+package providers
+
+import (
+	"sort"
+	"strings"
+)
+
+// EventType 表示流式事件的类型
+type EventType int
+
+const (
+	EventTextDelta      EventType = iota // 回答文本增量
+	EventReasoningDelta                  // 推理（思考）过程增量
+	EventToolCallDelta                   // 工具调用增量
+	EventUsage                           // token 用量
+	EventFinish                          // 生成结束及原因
+	EventError                           // 流中途出现的错误
+)
+
+// StreamEvent 是 provider 在流式响应中产生的一个事件
+type StreamEvent struct {
+	Type         EventType
+	Text         string         // EventTextDelta / EventReasoningDelta
+	ToolCall     *ToolCallDelta // EventToolCallDelta
+	Usage        *Usage         // EventUsage
+	FinishReason string         // EventFinish
+	ResponseID   string         // EventFinish：服务端保存的响应 ID，可用于继续对话（仅 Responses API）
+	Err          error          // EventError
+}
+
+// ToolCallDelta 是工具调用的增量片段，同一调用的片段以 Index 关联
+type ToolCallDelta struct {
+	Index     int
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// Usage 是一次调用的 token 用量
+//
+// EventUsage 事件携带的是截至当前的累计用量，后到的事件覆盖先到的事件。
+// Output 包含 Reasoning；Input 包含 Cached。
+type Usage struct {
+	Input     int
+	Output    int
+	Cached    int
+	Reasoning int
+}
+
+// EventHandler 接收流式事件；为 nil 时事件被丢弃
+type EventHandler func(StreamEvent)
+
+// emit 在 handler 非空时分发事件
+func (h EventHandler) emit(ev StreamEvent) {
+	if h != nil {
+		h(ev)
+	}
+}
+
+// Response 是由流式事件累积得到的完整响应
+type Response struct {
+	Text         string
+	Reasoning    string
+	FinishReason string
+	ID           string     // 服务端保存的响应 ID，作为下一次请求的 PreviousResponseID
+	ToolCalls    []ToolCall // 按调用序号排列
+	Usage        *Usage     // provider 未返回用量时为 nil
+}
+
+// Accumulator 将流式事件累积为完整响应
+type Accumulator struct {
+	text      strings.Builder
+	reasoning strings.Builder
+	finish    string
+	id        string
+	toolCalls map[int]*ToolCall
+	usage     *Usage
+}
+
+// Add 合并一个事件
+func (a *Accumulator) Add(ev StreamEvent) {
+	switch ev.Type {
+	case EventTextDelta:
+		a.text.WriteString(ev.Text)
+	case EventReasoningDelta:
+		a.reasoning.WriteString(ev.Text)
+	case EventToolCallDelta:
+		a.addToolCall(ev.ToolCall)
+	case EventFinish:
+		a.finish = ev.FinishReason
+		a.id = ev.ResponseID
+	case EventUsage:
+		a.usage = ev.Usage
+	}
+}
+
+// Response 返回目前为止累积的响应
+func (a *Accumulator) Response() *Response {
+	return &Response{
+		Text:         a.text.String(),
+		Reasoning:    a.reasoning.String(),
+		FinishReason: a.finish,
+		ID:           a.id,
+		ToolCalls:    a.completedToolCalls(),
+		Usage:        a.usage,
+	}
+}
+
+// addToolCall 按 Index 合并工具调用片段：ID 和名称取首次出现的值，参数依次拼接
+func (a *Accumulator) addToolCall(delta *ToolCallDelta) {
+	if delta == nil {
+		return
+	}
+	if a.toolCalls == nil {
+		a.toolCalls = make(map[int]*ToolCall)
+	}
+	call, exists := a.toolCalls[delta.Index]
+	if !exists {
+		call = &ToolCall{}
+		a.toolCalls[delta.Index] = call
+	}
+	if call.ID == "" {
+		call.ID = delta.ID
+	}
+	if call.Name == "" {
+		call.Name = delta.Name
+	}
+	call.Arguments += delta.Arguments
+}
+
+// completedToolCalls 返回按序号排列的工具调用，没有参数的调用使用 {}
+func (a *Accumulator) completedToolCalls() []ToolCall {
+	indexes := make([]int, 0, len(a.toolCalls))
+	for index := range a.toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	var calls []ToolCall
+	for _, index := range indexes {
+		call := *a.toolCalls[index]
+		if strings.TrimSpace(call.Arguments) == "" {
+			call.Arguments = "{}"
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
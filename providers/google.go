@@ -1,276 +1,381 @@
 package providers
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"strings"
-	"time"
+
+	"sse-client/providers/sse"
 )
 
-type GoogleProvider struct{}
+// GoogleProvider 调用 Google 格式的 API，name 为配置中的 provider 名称
+type GoogleProvider struct {
+	name   string
+	vertex bool // 为 true 时通过 Vertex AI 访问，使用服务账号换取的访问令牌认证
+}
 
 type GoogleRequest struct {
-	Contents         []GoogleContent        `json:"contents"`
-	GenerationConfig GoogleGenerationConfig `json:"generationConfig"`
+	Contents          []GoogleContent        `json:"contents"`
+	SystemInstruction *GoogleContent         `json:"systemInstruction,omitempty"`
+	GenerationConfig  GoogleGenerationConfig `json:"generationConfig"`
+	Tools             []GoogleTool           `json:"tools,omitempty"`
+}
+
+// GoogleTool 包含一组函数声明
+type GoogleTool struct {
+	FunctionDeclarations []GoogleFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type GoogleFunctionDeclaration struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // Gemini 不接受空的 object schema，无参数时省略
 }
 
 type GoogleContent struct {
+	Role  string       `json:"role,omitempty"`
 	Parts []GooglePart `json:"parts"`
 }
 
 type GooglePart struct {
-	Text string `json:"text"`
+	Text       string            `json:"text,omitempty"`
+	InlineData *GoogleInlineData `json:"inline_data,omitempty"`
+}
+
+// GoogleInlineData 是以 base64 内联发送的二进制内容（如图片）
+type GoogleInlineData struct {
+	MimeType string `json:"mime_type"`
+	Data     string `json:"data"`
 }
 
+// googleImageTypes 是 Gemini 接受的图片 MIME 类型
+var googleImageTypes = []string{"image/png", "image/jpeg", "image/webp", "image/heic", "image/heif"}
+
 type GoogleGenerationConfig struct {
-	Temperature     float64 `json:"temperature"`
-	MaxOutputTokens int     `json:"maxOutputTokens"`
+	Temperature     float64               `json:"temperature"`
+	MaxOutputTokens int                   `json:"maxOutputTokens"`
+	ThinkingConfig  *GoogleThinkingConfig `json:"thinkingConfig,omitempty"`
+
+	// 结构化输出：responseMimeType 为 application/json，responseSchema 为 OpenAPI 风格的 schema
+	ResponseMimeType string                 `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]interface{} `json:"responseSchema,omitempty"`
+}
+
+// GoogleThinkingConfig 控制 Gemini 2.5 系列的思考预算，includeThoughts 返回思考摘要
+type GoogleThinkingConfig struct {
+	ThinkingBudget  int  `json:"thinkingBudget"`
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
 }
 
 type GoogleResponse struct {
 	Candidates []struct {
 		Content struct {
 			Parts []struct {
-				Text string `json:"text"`
+				Text         string `json:"text"`
+				Thought      bool   `json:"thought"`
+				FunctionCall *struct {
+					Name string          `json:"name"`
+					Args json.RawMessage `json:"args"`
+				} `json:"functionCall"`
 			} `json:"parts"`
 		} `json:"content"`
 		FinishReason string `json:"finishReason"`
 	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+		ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+	} `json:"usageMetadata"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
 }
 
-func NewGoogleProvider() *GoogleProvider {
-	return &GoogleProvider{}
+func NewGoogleProvider(name string) *GoogleProvider {
+	return &GoogleProvider{name: name}
 }
 
 func (p *GoogleProvider) SupportsModel(model string) bool {
-	models := GetModelsForProvider("google")
+	models := GetModelsForProvider(p.name)
 	return ModelInList(model, models)
 }
 
-func (p *GoogleProvider) Stream(model, message string, temperature float64, maxTokens, timeout int) error {
-	cfg, exists := GetProviderConfig("google")
-	if !exists {
-		return GetProviderNotConfiguredError("google")
-	}
-
-	baseURL := cfg.BaseURL
-	apiKey := cfg.APIKey
-
-	if apiKey == "" {
-		return GetAPIKeyConfigError("google")
-	}
-
-	// 修正 URL 格式 - Google Gemini API 使用不同的端点
-	url := fmt.Sprintf("%s/%s:streamGenerateContent?alt=sse&key=%s", baseURL, model, apiKey)
-
-	req := GoogleRequest{
-		Contents: []GoogleContent{
-			{
-				Parts: []GooglePart{
-					{Text: message},
-				},
-			},
-		},
+// newGoogleRequest 将通用请求转换为 Gemini 格式：assistant 对应 model 角色，system 消息放入 systemInstruction
+func newGoogleRequest(req *ChatRequest) (GoogleRequest, error) {
+	googleReq := GoogleRequest{
 		GenerationConfig: GoogleGenerationConfig{
-			Temperature:     temperature,
-			MaxOutputTokens: maxTokens,
+			Temperature:     req.Temperature,
+			MaxOutputTokens: req.MaxTokens,
 		},
 	}
 
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
+	googleReq.GenerationConfig.ThinkingConfig = newGoogleThinkingConfig(req.Model, req.Reasoning)
 
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
+	if format := req.ResponseFormat; format != nil {
+		googleReq.GenerationConfig.ResponseMimeType = "application/json"
+		if len(format.Schema) > 0 {
+			var schema map[string]interface{}
+			if err := json.Unmarshal(format.Schema, &schema); err != nil {
+				return GoogleRequest{}, fmt.Errorf("invalid response schema: %v", err)
+			}
+			googleReq.GenerationConfig.ResponseSchema = toGoogleSchema(schema)
+		}
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return err
+	if len(req.Tools) > 0 {
+		var declarations []GoogleFunctionDeclaration
+		for _, tool := range req.Tools {
+			declarations = append(declarations, GoogleFunctionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			})
+		}
+		googleReq.Tools = []GoogleTool{{FunctionDeclarations: declarations}}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("Google API error (status %d): %s", resp.StatusCode, string(body))
+	if system := req.SystemPrompt(); system != "" {
+		googleReq.SystemInstruction = &GoogleContent{Parts: []GooglePart{{Text: system}}}
 	}
 
-	// 检查响应的 Content-Type 来判断是否为流式响应
-	contentType := resp.Header.Get("Content-Type")
-
-	if strings.Contains(contentType, "text/event-stream") || strings.Contains(contentType, "application/x-ndjson") {
-		// 真正的流式响应处理
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-
-			// 跳过空行
-			if line == "" {
-				continue
-			}
+	for _, msg := range req.Messages {
+		role := "user"
+		switch msg.Role {
+		case RoleSystem:
+			continue
+		case RoleAssistant:
+			role = "model"
+		}
 
-			// 处理 SSE 格式
-			if strings.HasPrefix(line, "data: ") {
-				data := strings.TrimPrefix(line, "data: ")
+		parts, err := newGoogleParts(msg)
+		if err != nil {
+			return GoogleRequest{}, err
+		}
+		googleReq.Contents = append(googleReq.Contents, GoogleContent{
+			Role:  role,
+			Parts: parts,
+		})
+	}
 
-				// 跳过结束标记
-				if data == "[DONE]" || data == "" {
-					break
-				}
+	return googleReq, nil
+}
 
-				var response GoogleResponse
-				if err := json.Unmarshal([]byte(data), &response); err == nil {
-					if len(response.Candidates) > 0 && len(response.Candidates[0].Content.Parts) > 0 {
-						text := response.Candidates[0].Content.Parts[0].Text
-						fmt.Print(text)
+// googleUnsupportedSchemaKeys 是 responseSchema 不接受的 JSON Schema 关键字，发送前移除，由本地校验兜底
+var googleUnsupportedSchemaKeys = []string{"$schema", "$id", "$comment", "additionalProperties", "const", "pattern"}
 
-						// 检查是否完成
-						if response.Candidates[0].FinishReason != "" && response.Candidates[0].FinishReason != "STOP" {
-							break
-						}
+// toGoogleSchema 移除 Gemini responseSchema 不支持的关键字
+func toGoogleSchema(schema map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		if ModelInList(key, googleUnsupportedSchemaKeys) {
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if key == "properties" {
+				properties := make(map[string]interface{}, len(v))
+				for name, prop := range v {
+					if propSchema, ok := prop.(map[string]interface{}); ok {
+						properties[name] = toGoogleSchema(propSchema)
+					} else {
+						properties[name] = prop
 					}
 				}
+				result[key] = properties
 			} else {
-				// 尝试直接解析 JSON 行
-				var response GoogleResponse
-				if err := json.Unmarshal([]byte(line), &response); err == nil {
-					if len(response.Candidates) > 0 && len(response.Candidates[0].Content.Parts) > 0 {
-						text := response.Candidates[0].Content.Parts[0].Text
-						fmt.Print(text)
-					}
+				result[key] = toGoogleSchema(v)
+			}
+		case []interface{}:
+			items := make([]interface{}, len(v))
+			for i, item := range v {
+				if itemSchema, ok := item.(map[string]interface{}); ok {
+					items[i] = toGoogleSchema(itemSchema)
+				} else {
+					items[i] = item
 				}
 			}
+			result[key] = items
+		default:
+			result[key] = value
 		}
-		fmt.Println()
-		return scanner.Err()
-	} else {
-		// 非流式响应，一次性读取完整响应
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
+	}
+	return result
+}
 
-		var response GoogleResponse
-		if err := json.Unmarshal(body, &response); err != nil {
-			return fmt.Errorf("failed to parse response: %v", err)
+// newGoogleThinkingConfig 将推理强度映射为 thinkingBudget；2.5 Pro 无法关闭思考，off 时使用最小预算
+func newGoogleThinkingConfig(model string, level ReasoningLevel) *GoogleThinkingConfig {
+	switch {
+	case level == ReasoningOff:
+		if strings.Contains(model, "pro") {
+			return &GoogleThinkingConfig{ThinkingBudget: 128}
 		}
+		return &GoogleThinkingConfig{ThinkingBudget: 0}
+	case level.Enabled():
+		return &GoogleThinkingConfig{ThinkingBudget: level.Budget(), IncludeThoughts: true}
+	}
+	return nil
+}
 
-		if len(response.Candidates) > 0 && len(response.Candidates[0].Content.Parts) > 0 {
-			text := response.Candidates[0].Content.Parts[0].Text
+// newGoogleParts 将消息内容转换为 Gemini parts，图片以 inline_data 形式发送
+func newGoogleParts(msg Message) ([]GooglePart, error) {
+	// 纯文本消息合并为单个文本 part
+	if !msg.HasImages() {
+		return []GooglePart{{Text: msg.Text()}}, nil
+	}
 
-			// 模拟流式输出效果
-			for _, char := range text {
-				fmt.Print(string(char))
-				time.Sleep(10 * time.Millisecond) // 模拟打字机效果
+	var parts []GooglePart
+	for _, part := range msg.Parts {
+		if part.Type != PartImage {
+			if text := part.AsText(); text != "" {
+				parts = append(parts, GooglePart{Text: text})
 			}
-			fmt.Println()
+			continue
 		}
 
-		return nil
-	}
-}
-func (p *GoogleProvider) StreamWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
-	// Google 的图片支持暂未实现
-	if imagePath != "" {
-		return fmt.Errorf("image support for Google models not implemented yet")
+		encodedImage, mimeType, err := EncodeImageToBase64(part.ImagePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode image: %v", err)
+		}
+		if err := ValidateImageMIMEType("google", part.ImagePath, mimeType, googleImageTypes); err != nil {
+			return nil, err
+		}
+		parts = append(parts, GooglePart{InlineData: &GoogleInlineData{
+			MimeType: mimeType,
+			Data:     encodedImage,
+		}})
 	}
-	return p.Stream(model, message, temperature, maxTokens, timeout)
+	return parts, nil
 }
 
-// GetFullResponse 获取完整的AI响应（非流式）
-func (p *GoogleProvider) GetFullResponse(model, message string, temperature float64, maxTokens, timeout int) (string, error) {
-	cfg, exists := GetProviderConfig("google")
+// Stream 发起流式请求，并将解析出的事件交给 handler
+func (p *GoogleProvider) Stream(ctx context.Context, req *ChatRequest, handler EventHandler) error {
+	cfg, exists := GetProviderConfig(p.name)
 	if !exists {
-		return "", GetProviderNotConfiguredError("google")
+		return GetProviderNotConfiguredError(p.name)
 	}
 
-	baseURL := cfg.BaseURL
-	apiKey := cfg.APIKey
-
-	if apiKey == "" {
-		return "", GetAPIKeyConfigError("google")
+	googleReq, err := newGoogleRequest(req)
+	if err != nil {
+		return err
 	}
 
-	req := GoogleRequest{
-		Contents: []GoogleContent{
-			{
-				Parts: []GooglePart{
-					{Text: message},
-				},
-			},
-		},
-		GenerationConfig: GoogleGenerationConfig{
-			Temperature:     temperature,
-			MaxOutputTokens: maxTokens,
-		},
+	var url string
+	var headers map[string]string
+	if p.vertex {
+		// Vertex AI 使用 projects/{p}/locations/{l}/publishers/google/models/{m} 路径和 OAuth2 访问令牌
+		url, headers, err = vertexEndpoint(ctx, p.name, cfg, req.Model)
+		if err != nil {
+			return err
+		}
+	} else {
+		if cfg.APIKey == "" {
+			return GetAPIKeyConfigError(p.name)
+		}
+		// Google Gemini API 使用 {base_url}/{model}:streamGenerateContent 端点，API key 通过请求头传递以免出现在错误信息的 URL 中
+		url = fmt.Sprintf("%s/%s:streamGenerateContent?alt=sse", cfg.BaseURL, req.Model)
+		headers = authHeaders(cfg, "x-goog-api-key", "", nil)
 	}
 
-	jsonData, err := json.Marshal(req)
+	resp, err := postStream(ctx, url, googleReq, headers, req)
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer resp.Body.Close()
 
-	url := fmt.Sprintf("%s/v1beta/models/%s:streamGenerateContent?alt=sse&key=%s", baseURL, model, apiKey)
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
+	// 检查响应的 Content-Type 来判断是否为流式响应
+	contentType := resp.Header.Get("Content-Type")
+	if strings.Contains(contentType, "text/event-stream") {
+		return contextError(ctx, decodeGoogleStream(p.name, resp.Body, handler))
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	resp, err := client.Do(httpReq)
+	// 非流式响应，一次性读取完整响应
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return contextError(ctx, err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API error: %s", string(body))
+	var response GoogleResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
 	}
+	var toolCalls int
+	return emitGoogleResponse(p.name, &response, handler, &toolCalls)
+}
 
-	var fullResponse strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "" {
-				continue
-			}
+// decodeGoogleStream 解析 Gemini 的 SSE 响应，并将事件交给 handler
+func decodeGoogleStream(providerName string, body io.Reader, handler EventHandler) error {
+	var toolCalls int
+	reader := sse.NewReader(body)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.Data == "[DONE]" {
+			return nil
+		}
 
-			var response GoogleResponse
-			if err := json.Unmarshal([]byte(data), &response); err == nil {
-				if len(response.Candidates) > 0 && len(response.Candidates[0].Content.Parts) > 0 {
-					fullResponse.WriteString(response.Candidates[0].Content.Parts[0].Text)
-				}
-			}
+		var response GoogleResponse
+		if err := json.Unmarshal([]byte(event.Data), &response); err != nil {
+			return decodeChunkError(providerName, event.Data, err)
+		}
+		if err := emitGoogleResponse(providerName, &response, handler, &toolCalls); err != nil {
+			return err
 		}
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		return "", err
+// emitGoogleResponse 将一个 Gemini 响应块转换为事件；Gemini 的 functionCall 总是完整返回，
+// toolCalls 记录已出现的调用数，作为下一个调用的序号
+func emitGoogleResponse(providerName string, response *GoogleResponse, handler EventHandler, toolCalls *int) error {
+	if response.Error != nil {
+		err := &StreamError{Provider: providerName, Type: response.Error.Status, Message: response.Error.Message}
+		handler.emit(StreamEvent{Type: EventError, Err: err})
+		return err
 	}
 
-	return fullResponse.String(), nil
-}
+	if len(response.Candidates) > 0 {
+		candidate := response.Candidates[0]
+		for _, part := range candidate.Content.Parts {
+			if call := part.FunctionCall; call != nil {
+				handler.emit(StreamEvent{Type: EventToolCallDelta, ToolCall: &ToolCallDelta{
+					Index:     *toolCalls,
+					Name:      call.Name,
+					Arguments: string(call.Args),
+				}})
+				*toolCalls++
+				continue
+			}
+			if part.Text == "" {
+				continue
+			}
+			if part.Thought {
+				handler.emit(StreamEvent{Type: EventReasoningDelta, Text: part.Text})
+			} else {
+				handler.emit(StreamEvent{Type: EventTextDelta, Text: part.Text})
+			}
+		}
+		if candidate.FinishReason != "" {
+			handler.emit(StreamEvent{Type: EventFinish, FinishReason: candidate.FinishReason})
+		}
+	}
 
-// GetFullResponseWithImage 获取完整的AI响应（非流式，支持图片）
-func (p *GoogleProvider) GetFullResponseWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) (string, error) {
-	// Google 的图片支持暂未实现
-	if imagePath != "" {
-		return "", fmt.Errorf("image support for Google models not implemented yet")
+	// usageMetadata 在每个数据块中给出截至当前的累计用量
+	if meta := response.UsageMetadata; meta != nil {
+		handler.emit(StreamEvent{Type: EventUsage, Usage: &Usage{
+			Input:     meta.PromptTokenCount,
+			Output:    meta.CandidatesTokenCount + meta.ThoughtsTokenCount,
+			Cached:    meta.CachedContentTokenCount,
+			Reasoning: meta.ThoughtsTokenCount,
+		}})
 	}
-	return p.GetFullResponse(model, message, temperature, maxTokens, timeout)
+	return nil
 }
@@ -0,0 +1,237 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OllamaDefaultBaseURL 是未配置 base_url 时使用的本地 Ollama 地址
+const OllamaDefaultBaseURL = "http://localhost:11434"
+
+// OllamaProvider 调用 Ollama 原生的 /api/chat 接口，流式响应为 NDJSON（每行一个 JSON 对象）而不是 SSE；
+// 本地服务不需要 API key
+type OllamaProvider struct {
+	name string
+}
+
+type OllamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  OllamaOptions   `json:"options"`
+	Think    *bool           `json:"think,omitempty"`  // 思考模型（如 qwen3、deepseek-r1）的推理开关
+	Format   json.RawMessage `json:"format,omitempty"` // "json" 或 JSON Schema
+	Tools    []OpenAITool    `json:"tools,omitempty"`
+}
+
+type OllamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"` // base64 编码的图片，不带 data URL 前缀
+}
+
+type OllamaOptions struct {
+	Temperature float64 `json:"temperature"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+}
+
+type OllamaResponse struct {
+	Message struct {
+		Content   string `json:"content"`
+		Thinking  string `json:"thinking"`
+		ToolCalls []struct {
+			Function struct {
+				Name      string          `json:"name"`
+				Arguments json.RawMessage `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+func NewOllamaProvider(name string) *OllamaProvider {
+	return &OllamaProvider{name: name}
+}
+
+func (p *OllamaProvider) SupportsModel(model string) bool {
+	models := GetModelsForProvider(p.name)
+	return ModelInList(model, models)
+}
+
+// newOllamaRequest 将通用请求转换为 Ollama /api/chat 格式，图片放入消息的 images 字段
+func newOllamaRequest(req *ChatRequest) (OllamaRequest, error) {
+	var messages []OllamaMessage
+	for _, msg := range req.Messages {
+		ollamaMsg := OllamaMessage{Role: string(msg.Role), Content: msg.Text()}
+		for _, part := range msg.Parts {
+			if part.Type != PartImage {
+				continue
+			}
+			encodedImage, _, err := EncodeImageToBase64(part.ImagePath)
+			if err != nil {
+				return OllamaRequest{}, fmt.Errorf("failed to encode image: %v", err)
+			}
+			ollamaMsg.Images = append(ollamaMsg.Images, encodedImage)
+		}
+		messages = append(messages, ollamaMsg)
+	}
+
+	ollamaReq := OllamaRequest{
+		Model:    req.Model,
+		Messages: messages,
+		Stream:   true,
+		Options: OllamaOptions{
+			Temperature: req.Temperature,
+			NumPredict:  req.MaxTokens,
+		},
+		Tools: newOpenAITools(req.Tools),
+	}
+
+	if req.Reasoning != ReasoningDefault {
+		think := req.Reasoning.Enabled()
+		ollamaReq.Think = &think
+	}
+
+	if format := req.ResponseFormat; format != nil {
+		ollamaReq.Format = json.RawMessage(`"json"`)
+		if len(format.Schema) > 0 {
+			ollamaReq.Format = format.Schema
+		}
+	}
+
+	return ollamaReq, nil
+}
+
+// Stream 发起流式请求，并将解析出的事件交给 handler
+func (p *OllamaProvider) Stream(ctx context.Context, req *ChatRequest, handler EventHandler) error {
+	cfg, exists := GetProviderConfig(p.name)
+	if !exists {
+		return GetProviderNotConfiguredError(p.name)
+	}
+
+	ollamaReq, err := newOllamaRequest(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := postStream(ctx, ollamaBaseURL(cfg)+"/api/chat", ollamaReq, ollamaHeaders(cfg), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return contextError(ctx, decodeOllamaStream(p.name, resp.Body, handler))
+}
+
+// ListModels 通过 /api/tags 返回本地已安装的模型
+func (p *OllamaProvider) ListModels(ctx context.Context) ([]string, error) {
+	cfg, exists := GetProviderConfig(p.name)
+	if !exists {
+		return nil, GetProviderNotConfiguredError(p.name)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", ollamaBaseURL(cfg)+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	for key, value := range ollamaHeaders(cfg) {
+		httpReq.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to parse model list: %v", err)
+	}
+
+	var models []string
+	for _, model := range tags.Models {
+		models = append(models, model.Name)
+	}
+	return models, nil
+}
+
+// ollamaBaseURL 返回去掉末尾斜杠的服务地址，未配置时使用本地默认地址
+func ollamaBaseURL(cfg ProviderConfig) string {
+	if cfg.BaseURL == "" {
+		return OllamaDefaultBaseURL
+	}
+	return strings.TrimSuffix(cfg.BaseURL, "/")
+}
+
+// ollamaHeaders 返回请求头；只有配置了 API key（如经过鉴权代理访问）时才携带认证头
+func ollamaHeaders(cfg ProviderConfig) map[string]string {
+	if cfg.APIKey == "" {
+		return cfg.Headers
+	}
+	return authHeaders(cfg, "Authorization", "Bearer", nil)
+}
+
+// decodeOllamaStream 解析 Ollama 的 NDJSON 响应，并将事件交给 handler
+func decodeOllamaStream(providerName string, body io.Reader, handler EventHandler) error {
+	// Ollama 的工具调用总是完整返回，toolCalls 作为下一个调用的序号
+	var toolCalls int
+	decoder := json.NewDecoder(body)
+	for {
+		var response OllamaResponse
+		if err := decoder.Decode(&response); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: failed to decode stream chunk: %v", providerName, err)
+		}
+
+		if response.Error != "" {
+			err := &StreamError{Provider: providerName, Message: response.Error}
+			handler.emit(StreamEvent{Type: EventError, Err: err})
+			return err
+		}
+
+		if response.Message.Thinking != "" {
+			handler.emit(StreamEvent{Type: EventReasoningDelta, Text: response.Message.Thinking})
+		}
+		if response.Message.Content != "" {
+			handler.emit(StreamEvent{Type: EventTextDelta, Text: response.Message.Content})
+		}
+		for _, call := range response.Message.ToolCalls {
+			handler.emit(StreamEvent{Type: EventToolCallDelta, ToolCall: &ToolCallDelta{
+				Index:     toolCalls,
+				Name:      call.Function.Name,
+				Arguments: string(call.Function.Arguments),
+			}})
+			toolCalls++
+		}
+
+		if response.Done {
+			handler.emit(StreamEvent{Type: EventUsage, Usage: &Usage{
+				Input:  response.PromptEvalCount,
+				Output: response.EvalCount,
+			}})
+			handler.emit(StreamEvent{Type: EventFinish, FinishReason: response.DoneReason})
+			return nil
+		}
+	}
+}
@@ -1,215 +1,326 @@
 package providers
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
+	"net/url"
 	"strings"
-	"time"
+
+	"sse-client/providers/sse"
 )
 
-type OpenAIProvider struct{}
+// OpenAIProvider 调用 OpenAI 格式的 API，name 为配置中的 provider 名称；
+// azure 为 true 时按 Azure OpenAI 的部署地址和 api-key 请求头访问
+type OpenAIProvider struct {
+	name  string
+	azure bool
+}
+
+// AzureDefaultAPIVersion 是未配置 api_version 时使用的 Azure OpenAI API 版本
+const AzureDefaultAPIVersion = "2024-10-21"
 
 type OpenAIRequest struct {
-	Model       string          `json:"model"`
-	Messages    []OpenAIMessage `json:"messages"`
-	MaxTokens   int             `json:"max_tokens"`
-	Temperature float64         `json:"temperature"`
-	Stream      bool            `json:"stream"`
+	Model               string                `json:"model"`
+	Messages            []OpenAIMessage       `json:"messages"`
+	MaxTokens           int                   `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int                   `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64              `json:"temperature,omitempty"`
+	ReasoningEffort     string                `json:"reasoning_effort,omitempty"`
+	Stream              bool                  `json:"stream"`
+	StreamOptions       *StreamOptions        `json:"stream_options,omitempty"`
+	Tools               []OpenAITool          `json:"tools,omitempty"`
+	ResponseFormat      *OpenAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// OpenAIResponseFormat 是 OpenAI 兼容接口的 response_format：json_object 或 json_schema
+type OpenAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *OpenAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type OpenAIJSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
 }
 
 type OpenAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+type OpenAITextContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type OpenAIImageContent struct {
+	Type     string         `json:"type"`
+	ImageURL OpenAIImageURL `json:"image_url"`
 }
 
+type OpenAIImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// openAIImageTypes 是 OpenAI chat/completions 接受的图片 MIME 类型
+var openAIImageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
 type OpenAIResponse struct {
 	Choices []struct {
 		Delta struct {
-			Content string `json:"content"`
+			Content          string                `json:"content"`
+			ReasoningContent string                `json:"reasoning_content"` // 推理模型（deepseek-r1、QwQ、Qwen3 等）的思考过程
+			ToolCalls        []OpenAIToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
 		FinishReason *string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *OpenAIUsage        `json:"usage"`
+	Error *StreamErrorPayload `json:"error"`
 }
 
-func NewOpenAIProvider() *OpenAIProvider {
-	return &OpenAIProvider{}
+// StreamOptions 控制 OpenAI 兼容接口的流式行为，IncludeUsage 让服务端在最后一个数据块中返回用量
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
-func (p *OpenAIProvider) SupportsModel(model string) bool {
-	models := GetModelsForProvider("openai")
-	return ModelInList(model, models)
+// OpenAIUsage 是 OpenAI 兼容接口返回的 token 用量
+type OpenAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	CompletionTokensDetails *struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens"` // DeepSeek 官方接口的缓存命中字段
 }
 
-func (p *OpenAIProvider) Stream(model, message string, temperature float64, maxTokens, timeout int) error {
-	cfg, exists := GetProviderConfig("openai")
-	if !exists {
-		return GetProviderNotConfiguredError("openai")
+func (u *OpenAIUsage) toUsage() *Usage {
+	usage := &Usage{
+		Input:  u.PromptTokens,
+		Output: u.CompletionTokens,
+		Cached: u.PromptCacheHitTokens,
+	}
+	if u.PromptTokensDetails != nil && u.PromptTokensDetails.CachedTokens > 0 {
+		usage.Cached = u.PromptTokensDetails.CachedTokens
+	}
+	if u.CompletionTokensDetails != nil {
+		usage.Reasoning = u.CompletionTokensDetails.ReasoningTokens
 	}
+	return usage
+}
 
-	baseURL := cfg.BaseURL
-	apiKey := cfg.APIKey
+func NewOpenAIProvider(name string) *OpenAIProvider {
+	return &OpenAIProvider{name: name}
+}
 
-	if apiKey == "" {
-		return GetAPIKeyConfigError("openai")
-	}
+// NewAzureOpenAIProvider 创建 Azure OpenAI 模式的 provider，base_url 为资源端点（如 https://xxx.openai.azure.com）
+func NewAzureOpenAIProvider(name string) *OpenAIProvider {
+	return &OpenAIProvider{name: name, azure: true}
+}
+
+func (p *OpenAIProvider) SupportsModel(model string) bool {
+	models := GetModelsForProvider(p.name)
+	return ModelInList(model, models)
+}
+
+// newOpenAIRequest 将通用请求转换为 OpenAI 格式
+func newOpenAIRequest(req *ChatRequest) (OpenAIRequest, error) {
+	var messages []OpenAIMessage
+	for _, msg := range req.Messages {
+		// 纯文本消息直接使用字符串内容
+		if !msg.HasImages() {
+			messages = append(messages, OpenAIMessage{Role: string(msg.Role), Content: msg.Text()})
+			continue
+		}
 
-	req := OpenAIRequest{
-		Model: model,
-		Messages: []OpenAIMessage{
-			{Role: "user", Content: message},
-		},
-		MaxTokens:   maxTokens,
-		Temperature: temperature,
-		Stream:      true,
+		// 构建多模态消息，图片以 data URL 形式放入 image_url 内容片段
+		var content []interface{}
+		for _, part := range msg.Parts {
+			if part.Type != PartImage {
+				if text := part.AsText(); text != "" {
+					content = append(content, OpenAITextContent{Type: "text", Text: text})
+				}
+				continue
+			}
+
+			encodedImage, mimeType, err := EncodeImageToBase64(part.ImagePath)
+			if err != nil {
+				return OpenAIRequest{}, fmt.Errorf("failed to encode image: %v", err)
+			}
+			if err := ValidateImageMIMEType("openai", part.ImagePath, mimeType, openAIImageTypes); err != nil {
+				return OpenAIRequest{}, err
+			}
+			content = append(content, OpenAIImageContent{
+				Type: "image_url",
+				ImageURL: OpenAIImageURL{
+					URL:    fmt.Sprintf("data:%s;base64,%s", mimeType, encodedImage),
+					Detail: part.Detail,
+				},
+			})
+		}
+		messages = append(messages, OpenAIMessage{Role: string(msg.Role), Content: content})
 	}
 
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return err
+	openaiReq := OpenAIRequest{
+		Model:          req.Model,
+		Messages:       messages,
+		Stream:         true,
+		StreamOptions:  &StreamOptions{IncludeUsage: true},
+		Tools:          newOpenAITools(req.Tools),
+		ResponseFormat: newOpenAIResponseFormat(req.ResponseFormat),
 	}
 
-	httpReq, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
+	if isOpenAIReasoningModel(req.Model) {
+		// o 系列和 gpt-5 推理模型不接受 temperature 和 max_tokens
+		openaiReq.MaxCompletionTokens = req.MaxTokens
+		openaiReq.ReasoningEffort = openAIReasoningEffort(req.Model, req.Reasoning)
+	} else {
+		temperature := req.Temperature
+		openaiReq.MaxTokens = req.MaxTokens
+		openaiReq.Temperature = &temperature
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	httpReq.Header.Set("Accept", "text/event-stream")
+	return openaiReq, nil
+}
 
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return err
+// newOpenAIResponseFormat 将结构化输出要求转换为 response_format，有 schema 时使用 json_schema
+func newOpenAIResponseFormat(format *ResponseFormat) *OpenAIResponseFormat {
+	if format == nil {
+		return nil
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error: %s", string(body))
+	if len(format.Schema) == 0 {
+		return &OpenAIResponseFormat{Type: "json_object"}
 	}
+	return &OpenAIResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &OpenAIJSONSchema{Name: format.schemaName(), Schema: format.Schema},
+	}
+}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
-
-			var response OpenAIResponse
-			if err := json.Unmarshal([]byte(data), &response); err == nil {
-				if len(response.Choices) > 0 {
-					fmt.Print(response.Choices[0].Delta.Content)
-					if response.Choices[0].FinishReason != nil {
-						break
-					}
-				}
-			}
+// isOpenAIReasoningModel 判断是否为支持 reasoning_effort 的推理模型（o1/o3/o4 系列和 gpt-5）
+func isOpenAIReasoningModel(model string) bool {
+	model = strings.ToLower(model)
+	if strings.HasPrefix(model, "gpt-5") {
+		return !strings.HasPrefix(model, "gpt-5-chat")
+	}
+	for _, prefix := range []string{"o1", "o3", "o4"} {
+		if strings.HasPrefix(model, prefix) {
+			return true
 		}
 	}
-
-	fmt.Println()
-	return scanner.Err()
+	return false
 }
-func (p *OpenAIProvider) StreamWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) error {
-	// OpenAI 的图片支持类似，但目前我们先简单实现为调用普通的 Stream 方法
-	// 因为 OpenAI 的多模态 API 格式稍有不同
-	if imagePath != "" {
-		return fmt.Errorf("image support for OpenAI models not implemented yet")
+
+// openAIReasoningEffort 将推理强度映射为 reasoning_effort；o 系列无法关闭推理，off 时使用模型默认值
+func openAIReasoningEffort(model string, level ReasoningLevel) string {
+	if level == ReasoningOff {
+		if strings.HasPrefix(strings.ToLower(model), "gpt-5") {
+			return "minimal"
+		}
+		return ""
 	}
-	return p.Stream(model, message, temperature, maxTokens, timeout)
+	return string(level)
 }
 
-// GetFullResponse 获取完整的AI响应（非流式）
-func (p *OpenAIProvider) GetFullResponse(model, message string, temperature float64, maxTokens, timeout int) (string, error) {
-	cfg, exists := GetProviderConfig("openai")
+// Stream 发起流式请求，并将解析出的事件交给 handler
+func (p *OpenAIProvider) Stream(ctx context.Context, req *ChatRequest, handler EventHandler) error {
+	cfg, exists := GetProviderConfig(p.name)
 	if !exists {
-		return "", GetProviderNotConfiguredError("openai")
+		return GetProviderNotConfiguredError(p.name)
 	}
 
 	baseURL := cfg.BaseURL
 	apiKey := cfg.APIKey
 
 	if apiKey == "" {
-		return "", GetAPIKeyConfigError("openai")
+		return GetAPIKeyConfigError(p.name)
 	}
 
-	req := OpenAIRequest{
-		Model: model,
-		Messages: []OpenAIMessage{
-			{Role: "user", Content: message},
-		},
-		MaxTokens:   maxTokens,
-		Temperature: temperature,
-		Stream:      true,
+	if !p.azure && usesResponsesAPI(cfg, req.Model) {
+		return p.streamResponses(ctx, cfg, req, handler)
 	}
-
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return "", err
+	if req.PreviousResponseID != "" {
+		return fmt.Errorf("%s: previous response ID requires the Responses API (model_options.%s.api: responses)", p.name, req.Model)
 	}
 
-	httpReq, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(jsonData))
+	openaiReq, err := newOpenAIRequest(req)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	httpReq.Header.Set("Accept", "text/event-stream")
+	headers := authHeaders(cfg, "Authorization", "Bearer", nil)
+	if p.azure {
+		baseURL = azureChatURL(cfg, req.Model)
+		headers = authHeaders(cfg, "api-key", "", nil)
+	}
 
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
-	resp, err := client.Do(httpReq)
+	resp, err := postStream(ctx, baseURL, openaiReq, headers, req)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API error: %s", string(body))
-	}
-
-	var fullResponse strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
-			}
+	return contextError(ctx, decodeOpenAIStream(p.name, resp.Body, handler))
+}
 
-			var response OpenAIResponse
-			if err := json.Unmarshal([]byte(data), &response); err == nil {
-				if len(response.Choices) > 0 {
-					fullResponse.WriteString(response.Choices[0].Delta.Content)
-					if response.Choices[0].FinishReason != nil {
-						break
-					}
-				}
-			}
-		}
+// azureChatURL 返回模型对应部署的 chat/completions 地址；未配置部署名时使用模型名作为部署名
+func azureChatURL(cfg ProviderConfig, model string) string {
+	deployment := model
+	if d, exists := cfg.Deployments[model]; exists && d != "" {
+		deployment = d
 	}
-
-	if err := scanner.Err(); err != nil {
-		return "", err
+	apiVersion := cfg.APIVersion
+	if apiVersion == "" {
+		apiVersion = AzureDefaultAPIVersion
 	}
-
-	return fullResponse.String(), nil
+	return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		strings.TrimSuffix(cfg.BaseURL, "/"), url.PathEscape(deployment), url.QueryEscape(apiVersion))
 }
 
-// GetFullResponseWithImage 获取完整的AI响应（非流式，支持图片）
-func (p *OpenAIProvider) GetFullResponseWithImage(model, message, imagePath string, temperature float64, maxTokens, timeout int) (string, error) {
-	// OpenAI 的图片支持暂未实现
-	if imagePath != "" {
-		return "", fmt.Errorf("image support for OpenAI models not implemented yet")
+// decodeOpenAIStream 解析 openai 的 SSE 响应，并将事件交给 handler
+func decodeOpenAIStream(providerName string, body io.Reader, handler EventHandler) error {
+	reader := sse.NewReader(body)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.Data == "[DONE]" {
+			return nil
+		}
+
+		var response OpenAIResponse
+		if err := json.Unmarshal([]byte(event.Data), &response); err != nil {
+			return decodeChunkError(providerName, event.Data, err)
+		}
+		if response.Error != nil {
+			err := &StreamError{Provider: providerName, Type: response.Error.Type, Message: response.Error.Message}
+			handler.emit(StreamEvent{Type: EventError, Err: err})
+			return err
+		}
+		if response.Usage != nil {
+			handler.emit(StreamEvent{Type: EventUsage, Usage: response.Usage.toUsage()})
+		}
+		if len(response.Choices) > 0 {
+			choice := response.Choices[0]
+			if choice.Delta.ReasoningContent != "" {
+				handler.emit(StreamEvent{Type: EventReasoningDelta, Text: choice.Delta.ReasoningContent})
+			}
+			if choice.Delta.Content != "" {
+				handler.emit(StreamEvent{Type: EventTextDelta, Text: choice.Delta.Content})
+			}
+			emitOpenAIToolCalls(choice.Delta.ToolCalls, handler)
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				handler.emit(StreamEvent{Type: EventFinish, FinishReason: *choice.FinishReason})
+			}
+		}
 	}
-	return p.GetFullResponse(model, message, temperature, maxTokens, timeout)
 }
@@ -0,0 +1,296 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"sse-client/providers/sse"
+)
+
+// OpenAI 模型可用的接口，通过 model_options.<model>.api 选择
+const (
+	APIChat      = "chat"      // /v1/chat/completions（默认）
+	APIResponses = "responses" // /v1/responses，支持推理摘要和 previous_response_id 服务端会话
+)
+
+// OpenAIResponsesRequest 是 /v1/responses 的请求体
+type OpenAIResponsesRequest struct {
+	Model              string                    `json:"model"`
+	Input              []OpenAIResponsesInput    `json:"input"`
+	Stream             bool                      `json:"stream"`
+	MaxOutputTokens    int                       `json:"max_output_tokens,omitempty"`
+	Temperature        *float64                  `json:"temperature,omitempty"`
+	Reasoning          *OpenAIResponsesReasoning `json:"reasoning,omitempty"`
+	Tools              []OpenAIResponsesTool     `json:"tools,omitempty"`
+	Text               *OpenAIResponsesText      `json:"text,omitempty"`
+	PreviousResponseID string                    `json:"previous_response_id,omitempty"`
+}
+
+// OpenAIResponsesInput 是输入中的一条消息，Content 为字符串或内容片段数组
+type OpenAIResponsesInput struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+type OpenAIResponsesContent struct {
+	Type     string `json:"type"` // input_text 或 input_image
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// OpenAIResponsesReasoning 设置推理强度，Summary 为 auto 时服务端流式返回推理摘要
+type OpenAIResponsesReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// OpenAIResponsesTool 是 Responses API 的函数工具定义，字段不再嵌套在 function 中
+type OpenAIResponsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// OpenAIResponsesText 是结构化输出设置，对应 chat/completions 的 response_format
+type OpenAIResponsesText struct {
+	Format OpenAIResponsesFormat `json:"format"`
+}
+
+type OpenAIResponsesFormat struct {
+	Type   string          `json:"type"` // json_object 或 json_schema
+	Name   string          `json:"name,omitempty"`
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+// OpenAIResponsesEvent 是 Responses API 流式返回的类型化事件，Type 如 response.output_text.delta
+type OpenAIResponsesEvent struct {
+	Type         string `json:"type"`
+	Delta        string `json:"delta"`
+	OutputIndex  int    `json:"output_index"`
+	SummaryIndex int    `json:"summary_index"`
+	Item         *struct {
+		Type      string `json:"type"`
+		CallID    string `json:"call_id"`
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"item"`
+	Response *OpenAIResponsesObject `json:"response"`
+	Code     string                 `json:"code"`    // error 事件
+	Message  string                 `json:"message"` // error 事件
+}
+
+// OpenAIResponsesObject 是 response.* 事件中携带的响应对象
+type OpenAIResponsesObject struct {
+	ID                string `json:"id"`
+	Status            string `json:"status"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Usage *struct {
+		InputTokens        int `json:"input_tokens"`
+		OutputTokens       int `json:"output_tokens"`
+		InputTokensDetails *struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"input_tokens_details"`
+		OutputTokensDetails *struct {
+			ReasoningTokens int `json:"reasoning_tokens"`
+		} `json:"output_tokens_details"`
+	} `json:"usage"`
+}
+
+// usesResponsesAPI 判断模型是否配置为使用 Responses API
+func usesResponsesAPI(cfg ProviderConfig, model string) bool {
+	return cfg.ModelAPIs[model] == APIResponses
+}
+
+// responsesURL 由 chat/completions 地址推导出 /responses 地址；base_url 只写到 /v1 时直接追加
+func responsesURL(baseURL string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if strings.HasSuffix(baseURL, "/chat/completions") {
+		return strings.TrimSuffix(baseURL, "/chat/completions") + "/responses"
+	}
+	return baseURL + "/responses"
+}
+
+// newOpenAIResponsesRequest 将通用请求转换为 Responses API 格式
+func newOpenAIResponsesRequest(req *ChatRequest) (OpenAIResponsesRequest, error) {
+	var input []OpenAIResponsesInput
+	for _, msg := range req.Messages {
+		if !msg.HasImages() {
+			input = append(input, OpenAIResponsesInput{Role: string(msg.Role), Content: msg.Text()})
+			continue
+		}
+
+		var content []OpenAIResponsesContent
+		for _, part := range msg.Parts {
+			if part.Type != PartImage {
+				if text := part.AsText(); text != "" {
+					content = append(content, OpenAIResponsesContent{Type: "input_text", Text: text})
+				}
+				continue
+			}
+
+			encodedImage, mimeType, err := EncodeImageToBase64(part.ImagePath)
+			if err != nil {
+				return OpenAIResponsesRequest{}, fmt.Errorf("failed to encode image: %v", err)
+			}
+			if err := ValidateImageMIMEType("openai", part.ImagePath, mimeType, openAIImageTypes); err != nil {
+				return OpenAIResponsesRequest{}, err
+			}
+			content = append(content, OpenAIResponsesContent{
+				Type:     "input_image",
+				ImageURL: fmt.Sprintf("data:%s;base64,%s", mimeType, encodedImage),
+				Detail:   part.Detail,
+			})
+		}
+		input = append(input, OpenAIResponsesInput{Role: string(msg.Role), Content: content})
+	}
+
+	responsesReq := OpenAIResponsesRequest{
+		Model:              req.Model,
+		Input:              input,
+		Stream:             true,
+		MaxOutputTokens:    req.MaxTokens,
+		PreviousResponseID: req.PreviousResponseID,
+	}
+
+	for _, tool := range req.Tools {
+		responsesReq.Tools = append(responsesReq.Tools, OpenAIResponsesTool{
+			Type:        "function",
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.toolParameters(),
+		})
+	}
+
+	if format := req.ResponseFormat; format != nil {
+		responsesReq.Text = &OpenAIResponsesText{Format: OpenAIResponsesFormat{Type: "json_object"}}
+		if len(format.Schema) > 0 {
+			responsesReq.Text.Format = OpenAIResponsesFormat{Type: "json_schema", Name: format.schemaName(), Schema: format.Schema}
+		}
+	}
+
+	if isOpenAIReasoningModel(req.Model) {
+		// 推理模型不接受 temperature，同时请求推理摘要作为推理过程输出
+		responsesReq.Reasoning = &OpenAIResponsesReasoning{
+			Effort:  openAIReasoningEffort(req.Model, req.Reasoning),
+			Summary: "auto",
+		}
+	} else {
+		temperature := req.Temperature
+		responsesReq.Temperature = &temperature
+	}
+
+	return responsesReq, nil
+}
+
+// streamResponses 通过 Responses API 发起流式请求
+func (p *OpenAIProvider) streamResponses(ctx context.Context, cfg ProviderConfig, req *ChatRequest, handler EventHandler) error {
+	responsesReq, err := newOpenAIResponsesRequest(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := postStream(ctx, responsesURL(cfg.BaseURL), responsesReq, authHeaders(cfg, "Authorization", "Bearer", nil), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return contextError(ctx, decodeOpenAIResponsesStream(p.name, resp.Body, handler))
+}
+
+// decodeOpenAIResponsesStream 解析 Responses API 的类型化事件流，并将事件交给 handler；
+// 工具调用以 output_index 关联，结束事件携带服务端保存的响应 ID
+func decodeOpenAIResponsesStream(providerName string, body io.Reader, handler EventHandler) error {
+	var hasToolCalls bool
+	reader := sse.NewReader(body)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.Data == "[DONE]" {
+			return nil
+		}
+
+		var ev OpenAIResponsesEvent
+		if err := json.Unmarshal([]byte(event.Data), &ev); err != nil {
+			return decodeChunkError(providerName, event.Data, err)
+		}
+
+		switch ev.Type {
+		case "response.output_text.delta":
+			handler.emit(StreamEvent{Type: EventTextDelta, Text: ev.Delta})
+		case "response.reasoning_summary_part.added":
+			// 多段推理摘要之间空一行
+			if ev.SummaryIndex > 0 {
+				handler.emit(StreamEvent{Type: EventReasoningDelta, Text: "\n\n"})
+			}
+		case "response.reasoning_summary_text.delta":
+			handler.emit(StreamEvent{Type: EventReasoningDelta, Text: ev.Delta})
+		case "response.output_item.added":
+			if ev.Item != nil && ev.Item.Type == "function_call" {
+				hasToolCalls = true
+				handler.emit(StreamEvent{Type: EventToolCallDelta, ToolCall: &ToolCallDelta{
+					Index:     ev.OutputIndex,
+					ID:        ev.Item.CallID,
+					Name:      ev.Item.Name,
+					Arguments: ev.Item.Arguments,
+				}})
+			}
+		case "response.function_call_arguments.delta":
+			handler.emit(StreamEvent{Type: EventToolCallDelta, ToolCall: &ToolCallDelta{
+				Index:     ev.OutputIndex,
+				Arguments: ev.Delta,
+			}})
+		case "response.completed", "response.incomplete":
+			if ev.Response == nil {
+				return nil
+			}
+			if usage := ev.Response.Usage; usage != nil {
+				u := &Usage{Input: usage.InputTokens, Output: usage.OutputTokens}
+				if usage.InputTokensDetails != nil {
+					u.Cached = usage.InputTokensDetails.CachedTokens
+				}
+				if usage.OutputTokensDetails != nil {
+					u.Reasoning = usage.OutputTokensDetails.ReasoningTokens
+				}
+				handler.emit(StreamEvent{Type: EventUsage, Usage: u})
+			}
+			reason := "stop"
+			if hasToolCalls {
+				reason = "tool_calls"
+			}
+			if ev.Response.IncompleteDetails != nil && ev.Response.IncompleteDetails.Reason != "" {
+				reason = ev.Response.IncompleteDetails.Reason
+			}
+			handler.emit(StreamEvent{Type: EventFinish, FinishReason: reason, ResponseID: ev.Response.ID})
+			return nil
+		case "response.failed":
+			err := &StreamError{Provider: providerName, Message: "response failed"}
+			if ev.Response != nil && ev.Response.Error != nil {
+				err.Type = ev.Response.Error.Code
+				err.Message = ev.Response.Error.Message
+			}
+			handler.emit(StreamEvent{Type: EventError, Err: err})
+			return err
+		case "error":
+			err := &StreamError{Provider: providerName, Type: ev.Code, Message: ev.Message}
+			handler.emit(StreamEvent{Type: EventError, Err: err})
+			return err
+		}
+	}
+}
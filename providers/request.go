@@ -0,0 +1,198 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Role 表示消息的角色
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// PartType 表示消息内容片段的类型
+type PartType string
+
+const (
+	PartText  PartType = "text"
+	PartImage PartType = "image"
+	PartFile  PartType = "file"
+)
+
+// ContentPart 是消息内容中的一个片段（文本、图片或文件）
+type ContentPart struct {
+	Type      PartType
+	Text      string // PartText 的文本，或 PartFile 的文件内容
+	ImagePath string // PartImage 的本地图片路径
+	Detail    string // PartImage 的解析精度（low/high/auto），仅部分 provider 支持
+	FileName  string // PartFile 的文件名
+}
+
+// Message 是对话中的一条消息
+type Message struct {
+	Role  Role
+	Parts []ContentPart
+}
+
+// ReasoningLevel 是与提供商无关的推理强度，由各 provider 映射为自己的参数
+type ReasoningLevel string
+
+const (
+	ReasoningDefault ReasoningLevel = ""       // 不设置，使用模型默认行为
+	ReasoningOff     ReasoningLevel = "off"    // 尽可能关闭推理
+	ReasoningLow     ReasoningLevel = "low"    // 少量推理
+	ReasoningMedium  ReasoningLevel = "medium" // 中等推理
+	ReasoningHigh    ReasoningLevel = "high"   // 充分推理
+)
+
+// ReasoningLevels 是可以通过 --reasoning 设置的推理强度
+var ReasoningLevels = []ReasoningLevel{ReasoningOff, ReasoningLow, ReasoningMedium, ReasoningHigh}
+
+// ParseReasoningLevel 解析推理强度，空字符串表示使用默认值
+func ParseReasoningLevel(s string) (ReasoningLevel, error) {
+	level := ReasoningLevel(strings.ToLower(strings.TrimSpace(s)))
+	if level == ReasoningDefault {
+		return level, nil
+	}
+	for _, l := range ReasoningLevels {
+		if l == level {
+			return level, nil
+		}
+	}
+	return "", fmt.Errorf("invalid reasoning level '%s' (expected: off, low, medium, high)", s)
+}
+
+// Enabled 判断是否明确要求开启推理
+func (l ReasoningLevel) Enabled() bool {
+	return l == ReasoningLow || l == ReasoningMedium || l == ReasoningHigh
+}
+
+// Budget 返回推理强度对应的思考 token 预算，用于按 token 数控制推理的 provider
+func (l ReasoningLevel) Budget() int {
+	switch l {
+	case ReasoningLow:
+		return 1024
+	case ReasoningMedium:
+		return 8192
+	case ReasoningHigh:
+		return 24576
+	}
+	return 0
+}
+
+// ChatRequest 是与提供商无关的对话请求，由各 provider 转换为自己的请求格式
+type ChatRequest struct {
+	Model       string
+	Messages    []Message
+	Temperature float64
+	MaxTokens   int
+	Timeout     int            // 请求超时（秒）
+	Reasoning   ReasoningLevel // 推理强度，为空时使用模型默认行为
+	Tools       []Tool         // 可供模型调用的工具
+
+	// ResponseFormat 要求模型只输出 JSON，为 nil 时输出普通文本
+	ResponseFormat *ResponseFormat
+
+	// PreviousResponseID 在服务端保存的上一次响应之后继续对话，只有使用 Responses API 的模型支持
+	PreviousResponseID string
+
+	// ExtraBody 是合并到请求体中的额外字段（如 top_p），嵌套对象逐层合并，同名字段覆盖原有值
+	ExtraBody map[string]interface{}
+}
+
+// ResponseFormat 描述结构化输出的要求；Schema 为空时只要求输出合法的 JSON 对象
+type ResponseFormat struct {
+	Name   string          // schema 名称，部分 provider 要求提供
+	Schema json.RawMessage // JSON Schema
+}
+
+// schemaName 返回 schema 名称，未设置时使用 "response"
+func (f *ResponseFormat) schemaName() string {
+	if f.Name == "" {
+		return "response"
+	}
+	return f.Name
+}
+
+// TextPart 创建文本片段
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: PartText, Text: text}
+}
+
+// ImagePart 创建图片片段
+func ImagePart(imagePath string) ContentPart {
+	return ContentPart{Type: PartImage, ImagePath: imagePath}
+}
+
+// ImageDetails 是图片片段可选的解析精度
+var ImageDetails = []string{"low", "high", "auto"}
+
+// FilePart 创建文件片段，文件内容以文本形式发送
+func FilePart(fileName, content string) ContentPart {
+	return ContentPart{Type: PartFile, FileName: fileName, Text: content}
+}
+
+// NewMessage 创建指定角色的消息
+func NewMessage(role Role, parts ...ContentPart) Message {
+	return Message{Role: role, Parts: parts}
+}
+
+// AsText 返回片段的文本表示；文件片段会带上文件名标题，图片片段返回空字符串
+func (p ContentPart) AsText() string {
+	switch p.Type {
+	case PartText:
+		return p.Text
+	case PartFile:
+		return fmt.Sprintf("文件内容 (%s):\n%s", p.FileName, p.Text)
+	}
+	return ""
+}
+
+// Text 将消息中的文本和文件片段拼接为纯文本
+func (m Message) Text() string {
+	var texts []string
+	for _, part := range m.Parts {
+		if text := part.AsText(); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// HasImages 判断消息是否包含图片片段
+func (m Message) HasImages() bool {
+	for _, part := range m.Parts {
+		if part.Type == PartImage {
+			return true
+		}
+	}
+	return false
+}
+
+// HasImages 判断请求中是否有任意消息包含图片
+func (r *ChatRequest) HasImages() bool {
+	for _, msg := range r.Messages {
+		if msg.HasImages() {
+			return true
+		}
+	}
+	return false
+}
+
+// SystemPrompt 将所有 system 消息拼接为一段文本，供不支持 system 角色消息的 API 使用
+func (r *ChatRequest) SystemPrompt() string {
+	var texts []string
+	for _, msg := range r.Messages {
+		if msg.Role == RoleSystem {
+			if text := msg.Text(); text != "" {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
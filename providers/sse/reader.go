@@ -0,0 +1,185 @@
+// Package sse 实现 text/event-stream（Server-Sent Events）格式的解析
+//
+// 解析规则遵循 WHATWG HTML 规范中的 event-stream 定义：
+// 支持 event、data、id、retry 字段与注释行，多行 data 以换行拼接，
+// 冒号后的单个空格可省略，行结束符可以是 CRLF、LF 或 CR，且不限制单行长度。
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultEventType 是未设置 event 字段时的事件类型
+const DefaultEventType = "message"
+
+// Event 表示一个完整的 SSE 事件
+type Event struct {
+	Type  string // event 字段，未设置时为 "message"
+	Data  string // 所有 data 字段以 "\n" 拼接后的内容
+	ID    string // 最近一次设置的 id（按规范会延续到后续事件）
+	Retry int    // retry 字段（毫秒），未设置时为 0
+}
+
+// Reader 从底层流中逐个读取 SSE 事件
+type Reader struct {
+	r        *bufio.Reader
+	lastID   string
+	skipLF   bool // 上一行以 CR 结束，需要跳过紧随其后的 LF
+	started  bool
+	lineBuf  []byte
+	dataBuf  strings.Builder
+	hasData  bool
+	evType   string
+	retry    int
+	retrySet bool
+}
+
+// NewReader 创建一个 SSE 读取器
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next 返回下一个事件；流正常结束时返回 io.EOF
+//
+// 按规范，流结束时尚未以空行终止的事件会被丢弃。
+func (r *Reader) Next() (*Event, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		// 空行：分发当前事件
+		if len(line) == 0 {
+			if ev := r.dispatch(); ev != nil {
+				return ev, nil
+			}
+			continue
+		}
+
+		// 注释行
+		if line[0] == ':' {
+			continue
+		}
+
+		var field, value string
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field = line[:i]
+			value = strings.TrimPrefix(line[i+1:], " ")
+		} else {
+			field = line
+		}
+
+		r.processField(field, value)
+	}
+}
+
+func (r *Reader) processField(field, value string) {
+	switch field {
+	case "event":
+		r.evType = value
+	case "data":
+		if r.hasData {
+			r.dataBuf.WriteByte('\n')
+		}
+		r.dataBuf.WriteString(value)
+		r.hasData = true
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			r.lastID = value
+		}
+	case "retry":
+		if isDigits(value) {
+			if n, err := strconv.Atoi(value); err == nil {
+				r.retry = n
+				r.retrySet = true
+			}
+		}
+	}
+	// 其他字段按规范忽略
+}
+
+// dispatch 组装并重置当前事件；没有 data 时返回 nil
+func (r *Reader) dispatch() *Event {
+	defer func() {
+		r.dataBuf.Reset()
+		r.hasData = false
+		r.evType = ""
+		r.retry = 0
+		r.retrySet = false
+	}()
+
+	if !r.hasData {
+		return nil
+	}
+
+	ev := &Event{
+		Type: r.evType,
+		Data: r.dataBuf.String(),
+		ID:   r.lastID,
+	}
+	if ev.Type == "" {
+		ev.Type = DefaultEventType
+	}
+	if r.retrySet {
+		ev.Retry = r.retry
+	}
+	return ev
+}
+
+// readLine 读取一行（不含行结束符），支持 CRLF、LF 和单独的 CR
+func (r *Reader) readLine() (string, error) {
+	r.lineBuf = r.lineBuf[:0]
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(r.lineBuf) > 0 {
+				// 最后一行没有行结束符，按规范该行不完整，直接结束
+				return "", io.EOF
+			}
+			return "", err
+		}
+
+		if r.skipLF {
+			r.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+
+		switch b {
+		case '\n':
+			return r.line(), nil
+		case '\r':
+			r.skipLF = true
+			return r.line(), nil
+		}
+		r.lineBuf = append(r.lineBuf, b)
+	}
+}
+
+func (r *Reader) line() string {
+	line := r.lineBuf
+	// 去掉流开头的 UTF-8 BOM
+	if !r.started {
+		r.started = true
+		line = bytes.TrimPrefix(line, []byte("\xEF\xBB\xBF"))
+	}
+	return string(line)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Tool 是与提供商无关的工具（函数）定义，由各 provider 转换为自己的 tools 格式
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage // 参数的 JSON Schema（type: object），为空时表示无参数
+}
+
+// ToolCall 是由流式增量重新组装得到的完整工具调用
+type ToolCall struct {
+	ID        string // Gemini 不返回调用 ID，此时为空
+	Name      string
+	Arguments string // JSON 格式的参数
+}
+
+// DecodeArguments 将调用参数解析到 v 中
+func (c ToolCall) DecodeArguments(v interface{}) error {
+	if err := json.Unmarshal([]byte(c.Arguments), v); err != nil {
+		return fmt.Errorf("failed to decode arguments of tool call %s: %v", c.Name, err)
+	}
+	return nil
+}
+
+// toolParameters 返回工具参数的 JSON Schema，未设置时使用空对象 schema
+func (t Tool) toolParameters() json.RawMessage {
+	if len(t.Parameters) == 0 {
+		return json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	return t.Parameters
+}
+
+// OpenAITool 是 OpenAI 兼容接口（OpenAI、百炼、DeepSeek）的工具定义
+type OpenAITool struct {
+	Type     string             `json:"type"`
+	Function OpenAIToolFunction `json:"function"`
+}
+
+type OpenAIToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// OpenAIToolCallDelta 是 OpenAI 兼容接口流式返回的工具调用片段，首个片段带有 id 和函数名
+type OpenAIToolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// newOpenAITools 将通用工具定义转换为 OpenAI 兼容格式
+func newOpenAITools(tools []Tool) []OpenAITool {
+	var openaiTools []OpenAITool
+	for _, tool := range tools {
+		openaiTools = append(openaiTools, OpenAITool{
+			Type: "function",
+			Function: OpenAIToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.toolParameters(),
+			},
+		})
+	}
+	return openaiTools
+}
+
+// emitOpenAIToolCalls 将 OpenAI 兼容接口的工具调用片段转换为事件
+func emitOpenAIToolCalls(deltas []OpenAIToolCallDelta, handler EventHandler) {
+	for _, delta := range deltas {
+		handler.emit(StreamEvent{Type: EventToolCallDelta, ToolCall: &ToolCallDelta{
+			Index:     delta.Index,
+			ID:        delta.ID,
+			Name:      delta.Function.Name,
+			Arguments: delta.Function.Arguments,
+		}})
+	}
+}
@@ -1,13 +1,19 @@
 package providers
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ProviderConfig 结构体定义
@@ -15,6 +21,19 @@ type ProviderConfig struct {
 	APIKey  string   `yaml:"api_key"`
 	BaseURL string   `yaml:"base_url"`
 	Models  []string `yaml:"models"`
+
+	AuthHeader string            `yaml:"auth_header"` // 携带 API key 的请求头，为空时使用该类型 API 的默认值
+	AuthScheme string            `yaml:"auth_scheme"` // API key 前的认证方案（如 Bearer），"none" 表示直接发送 key
+	Headers    map[string]string `yaml:"headers"`     // 额外的请求头，可覆盖默认请求头
+
+	APIVersion  string            `yaml:"api_version"` // Azure OpenAI 的 api-version
+	Deployments map[string]string `yaml:"deployments"` // Azure OpenAI 中模型名到部署名的映射
+	ModelAPIs   map[string]string `yaml:"model_apis"`  // OpenAI 模型名到接口（chat 或 responses）的映射
+
+	CredentialsFile string `yaml:"credentials_file"` // Vertex AI 服务账号 JSON 凭据文件
+	Project         string `yaml:"project"`          // Vertex AI 项目 ID，为空时使用凭据中的 project_id
+	Location        string `yaml:"location"`         // Vertex AI 区域，默认 us-central1
+	TokenURL        string `yaml:"token_url"`        // OAuth2 令牌端点，为空时使用凭据中的 token_uri
 }
 
 // Config 结构体定义
@@ -30,6 +49,14 @@ func SetConfig(cfg Config) {
 	config = cfg
 }
 
+// SetProviderConfig 更新单个 provider 的配置，用于按需解析的密钥（如 api_key_cmd）
+func SetProviderConfig(provider string, cfg ProviderConfig) {
+	if config.Providers == nil {
+		config.Providers = make(map[string]ProviderConfig)
+	}
+	config.Providers[provider] = cfg
+}
+
 // GetConfig 获取配置
 func GetConfig() Config {
 	return config
@@ -56,9 +83,51 @@ func GetProviderConfig(provider string) (ProviderConfig, bool) {
 	return cfg, exists
 }
 
+// EnvPrefix 返回 provider 对应的环境变量前缀：名称转为大写，非字母数字字符替换为下划线，
+// 如 my-vllm 对应 MY_VLLM_API_KEY
+func EnvPrefix(providerName string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, providerName)
+}
+
+// authHeaders 根据 provider 配置构造请求头：defaultHeader / defaultScheme 是该类型 API 的默认认证方式，
+// defaults 是该类型 API 的其他默认请求头，配置中的 headers 最后应用，可以覆盖前两者
+func authHeaders(cfg ProviderConfig, defaultHeader, defaultScheme string, defaults map[string]string) map[string]string {
+	header := defaultHeader
+	if cfg.AuthHeader != "" {
+		header = cfg.AuthHeader
+	}
+	scheme := defaultScheme
+	if cfg.AuthScheme != "" {
+		scheme = cfg.AuthScheme
+	}
+
+	value := cfg.APIKey
+	if scheme != "" && !strings.EqualFold(scheme, "none") {
+		value = scheme + " " + cfg.APIKey
+	}
+
+	headers := make(map[string]string)
+	for key, v := range defaults {
+		headers[http.CanonicalHeaderKey(key)] = v
+	}
+	headers[http.CanonicalHeaderKey(header)] = value
+	for key, v := range cfg.Headers {
+		headers[http.CanonicalHeaderKey(key)] = v
+	}
+	return headers
+}
+
 // GetAPIKeyConfigError 返回带有配置指导的 API key 错误信息
 func GetAPIKeyConfigError(providerName string) error {
-	providerUpper := strings.ToUpper(providerName)
+	providerUpper := EnvPrefix(providerName)
 	return fmt.Errorf(`%s API key not configured
 
 Please configure your API key using one of these methods:
@@ -77,7 +146,7 @@ Then try your command again.`, providerName, providerUpper, providerName)
 
 // GetProviderNotConfiguredError 返回带有配置指导的 provider 未配置错误信息
 func GetProviderNotConfiguredError(providerName string) error {
-	providerUpper := strings.ToUpper(providerName)
+	providerUpper := EnvPrefix(providerName)
 	return fmt.Errorf(`%s provider not configured
 
 Please configure the provider using one of these methods:
@@ -131,6 +200,17 @@ func EncodeImageToBase64(imagePath string) (string, string, error) {
 	return encoded, mimeType, nil
 }
 
+// ValidateImageMIMEType 检查图片的 MIME 类型是否被 provider 支持，在上传前拒绝不支持的格式
+func ValidateImageMIMEType(providerName, imagePath, mimeType string, supported []string) error {
+	for _, t := range supported {
+		if strings.EqualFold(t, mimeType) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported image type %s for %s: %s (supported: %s)",
+		mimeType, providerName, imagePath, strings.Join(supported, ", "))
+}
+
 // ConvertImageToBase64URL 将图片转换为 base64 URL 格式
 func ConvertImageToBase64URL(imagePath string) (string, error) {
 	encoded, mimeType, err := EncodeImageToBase64(imagePath)
@@ -141,3 +221,173 @@ func ConvertImageToBase64URL(imagePath string) (string, error) {
 	// 返回 data URL 格式
 	return fmt.Sprintf("data:%s;base64,%s", mimeType, encoded), nil
 }
+
+// StreamError 表示服务端在流式响应中途返回的错误事件
+type StreamError struct {
+	Provider string
+	Type     string
+	Message  string
+}
+
+func (e *StreamError) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("%s stream error (%s): %s", e.Provider, e.Type, e.Message)
+	}
+	return fmt.Sprintf("%s stream error: %s", e.Provider, e.Message)
+}
+
+// Retryable 判断流中途的错误是否为服务端过载、限流等临时错误
+func (e *StreamError) Retryable() bool {
+	switch e.Type {
+	case "overloaded_error", "rate_limit_error", "api_error", "server_error", "UNAVAILABLE", "RESOURCE_EXHAUSTED":
+		return true
+	}
+	return false
+}
+
+// StreamErrorPayload 是 OpenAI 兼容接口在流中返回的错误结构
+type StreamErrorPayload struct {
+	Message string      `json:"message"`
+	Type    string      `json:"type"`
+	Code    interface{} `json:"code"`
+}
+
+// decodeChunkError 返回流数据块无法解析时的错误
+func decodeChunkError(providerName string, data string, err error) error {
+	if len(data) > 200 {
+		data = data[:200] + "..."
+	}
+	return fmt.Errorf("%s: failed to decode stream chunk: %v (data: %s)", providerName, err, data)
+}
+
+// contextError 在请求被取消或超时时返回 ctx.Err()，便于调用方区分中断与普通错误
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// postStream 以 JSON 请求体发起流式请求，返回状态码为 200 的响应，调用方负责关闭 Body；
+// 请求超时取自 req.Timeout，req.ExtraBody 合并到请求体中
+func postStream(ctx context.Context, url string, payload interface{}, headers map[string]string, req *ChatRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %v", err)
+	}
+	if len(req.ExtraBody) > 0 {
+		if jsonData, err = mergeExtraBody(jsonData, req.ExtraBody); err != nil {
+			return nil, err
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	for key, value := range headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: time.Duration(req.Timeout) * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header, time.Now()),
+		}
+	}
+
+	return resp, nil
+}
+
+// mergeExtraBody 将额外字段合并到 JSON 请求体中；数字按原样保留，避免大整数丢失精度
+func mergeExtraBody(jsonData []byte, extra map[string]interface{}) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	var body map[string]interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return nil, fmt.Errorf("error merging extra body: %v", err)
+	}
+	mergeJSONObject(body, extra)
+	merged, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling extra body: %v", err)
+	}
+	return merged, nil
+}
+
+// mergeJSONObject 将 src 合并到 dst：两边都是对象时逐层合并，否则用 src 的值覆盖
+func mergeJSONObject(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcObject, srcIsObject := value.(map[string]interface{})
+		dstObject, dstIsObject := dst[key].(map[string]interface{})
+		if srcIsObject && dstIsObject {
+			mergeJSONObject(dstObject, srcObject)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// APIError 表示服务端返回了非 200 状态码
+type APIError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // 服务端通过 Retry-After 或 x-ratelimit-reset-* 建议的等待时间，未给出时为 0
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// Retryable 判断该错误是否值得重试：限流（429）和服务端错误（5xx）
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// parseRetryAfter 解析 Retry-After（秒数或 HTTP 日期）和 x-ratelimit-reset-*（如 "1s"、"6m0s"、秒数或 RFC 3339 时间），
+// 返回其中最长的等待时间
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	var wait time.Duration
+	for key, values := range header {
+		lower := strings.ToLower(key)
+		if lower != "retry-after" && !strings.HasPrefix(lower, "x-ratelimit-reset") {
+			continue
+		}
+		for _, value := range values {
+			if d := parseWaitValue(strings.TrimSpace(value), now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+func parseWaitValue(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	for _, layout := range []string{http.TimeFormat, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Sub(now)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,259 @@
+package providers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VertexDefaultLocation 是未配置 location 时使用的 Vertex AI 区域
+const VertexDefaultLocation = "us-central1"
+
+// VertexDefaultTokenURL 是服务账号凭据未指定 token_uri 时使用的 OAuth2 令牌端点
+const VertexDefaultTokenURL = "https://oauth2.googleapis.com/token"
+
+// vertexScope 是访问 Vertex AI 所需的 OAuth2 权限范围
+const vertexScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// vertexTokenRefreshMargin 是令牌到期前提前刷新的时间，避免请求途中令牌过期
+const vertexTokenRefreshMargin = time.Minute
+
+// serviceAccount 是服务账号 JSON 凭据文件中用到的字段
+type serviceAccount struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// vertexToken 是缓存的访问令牌
+type vertexToken struct {
+	value   string
+	expires time.Time
+}
+
+var (
+	vertexTokensMu sync.Mutex
+	vertexTokens   = make(map[string]vertexToken) // 按凭据文件和令牌端点缓存
+)
+
+// NewVertexProvider 创建 Vertex AI 模式的 Gemini provider，使用服务账号凭据换取的访问令牌认证
+func NewVertexProvider(name string) *GoogleProvider {
+	return &GoogleProvider{name: name, vertex: true}
+}
+
+// loadServiceAccount 读取服务账号 JSON 凭据文件
+func loadServiceAccount(path string) (*serviceAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+	var sa serviceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file %s: %v", path, err)
+	}
+	if sa.Type != "service_account" || sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return nil, fmt.Errorf("credentials file %s is not a service account key", path)
+	}
+	return &sa, nil
+}
+
+// vertexEndpoint 返回模型的 streamGenerateContent 地址和携带访问令牌的请求头
+func vertexEndpoint(ctx context.Context, providerName string, cfg ProviderConfig, model string) (string, map[string]string, error) {
+	if cfg.CredentialsFile == "" {
+		return "", nil, fmt.Errorf("%s: credentials_file is not configured (or set GOOGLE_APPLICATION_CREDENTIALS)", providerName)
+	}
+	sa, err := loadServiceAccount(cfg.CredentialsFile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	project := cfg.Project
+	if project == "" {
+		project = sa.ProjectID
+	}
+	if project == "" {
+		return "", nil, fmt.Errorf("%s: project is not configured", providerName)
+	}
+	location := cfg.Location
+	if location == "" {
+		location = VertexDefaultLocation
+	}
+
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = vertexBaseURL(location)
+	}
+	endpoint := fmt.Sprintf("%s/projects/%s/locations/%s/publishers/google/models/%s:streamGenerateContent?alt=sse",
+		baseURL, url.PathEscape(project), url.PathEscape(location), url.PathEscape(model))
+
+	token, err := vertexAccessToken(ctx, cfg, sa)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 访问令牌代替 API key 通过 Authorization: Bearer 发送，额外请求头仍然生效
+	cfg.APIKey = token
+	return endpoint, authHeaders(cfg, "Authorization", "Bearer", nil), nil
+}
+
+// vertexBaseURL 返回区域对应的 Vertex AI 服务地址，global 区域没有区域前缀
+func vertexBaseURL(location string) string {
+	if location == "global" {
+		return "https://aiplatform.googleapis.com/v1"
+	}
+	return fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1", location)
+}
+
+// vertexTokenURL 返回令牌端点：配置中的 token_url 优先，其次是凭据文件中的 token_uri
+func vertexTokenURL(cfg ProviderConfig, sa *serviceAccount) string {
+	if cfg.TokenURL != "" {
+		return cfg.TokenURL
+	}
+	if sa.TokenURI != "" {
+		return sa.TokenURI
+	}
+	return VertexDefaultTokenURL
+}
+
+// vertexAccessToken 返回缓存的访问令牌，即将过期或不存在时重新换取
+func vertexAccessToken(ctx context.Context, cfg ProviderConfig, sa *serviceAccount) (string, error) {
+	tokenURL := vertexTokenURL(cfg, sa)
+	key := cfg.CredentialsFile + "|" + tokenURL
+
+	vertexTokensMu.Lock()
+	defer vertexTokensMu.Unlock()
+
+	if token, exists := vertexTokens[key]; exists && time.Now().Add(vertexTokenRefreshMargin).Before(token.expires) {
+		return token.value, nil
+	}
+
+	token, err := exchangeServiceAccountJWT(ctx, sa, tokenURL, time.Now())
+	if err != nil {
+		return "", err
+	}
+	vertexTokens[key] = token
+	return token.value, nil
+}
+
+// exchangeServiceAccountJWT 用服务账号私钥签名的 JWT 向令牌端点换取访问令牌
+func exchangeServiceAccountJWT(ctx context.Context, sa *serviceAccount, tokenURL string, now time.Time) (vertexToken, error) {
+	assertion, err := signServiceAccountJWT(sa, tokenURL, now)
+	if err != nil {
+		return vertexToken{}, err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return vertexToken{}, fmt.Errorf("error creating token request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return vertexToken{}, contextError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return vertexToken{}, fmt.Errorf("failed to obtain access token (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return vertexToken{}, fmt.Errorf("failed to parse token response: %v", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return vertexToken{}, fmt.Errorf("token response has no access_token: %s", string(body))
+	}
+	if tokenResp.ExpiresIn <= 0 {
+		tokenResp.ExpiresIn = 3600
+	}
+
+	return vertexToken{
+		value:   tokenResp.AccessToken,
+		expires: now.Add(time.Duration(tokenResp.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// signServiceAccountJWT 生成 RS256 签名的 JWT 断言，有效期一小时
+func signServiceAccountJWT(sa *serviceAccount, audience string, now time.Time) (string, error) {
+	key, err := parseRSAPrivateKey(sa.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if sa.PrivateKeyID != "" {
+		header["kid"] = sa.PrivateKeyID
+	}
+	claims := map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": vertexScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseRSAPrivateKey 解析 PEM 格式的 RSA 私钥，支持 PKCS#8 和 PKCS#1
+func parseRSAPrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key in credentials file")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key in credentials file is not an RSA key")
+		}
+		return rsaKey, nil
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	return key, nil
+}